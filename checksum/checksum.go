@@ -0,0 +1,167 @@
+package checksum
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"libgen/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type Sums struct {
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+var ErrMismatch = errors.New("checksum mismatch")
+
+// Hasher computes the MD5 and SHA-256 of everything written to it.
+type Hasher struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	writer io.Writer
+}
+
+func NewHasher() *Hasher {
+	h := Hasher{
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+	h.writer = io.MultiWriter(h.md5, h.sha256)
+	return &h
+}
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.writer.Write(p)
+}
+func (h *Hasher) Sums() Sums {
+	return Sums{
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+	}
+}
+
+func File(path string) (Sums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Sums{}, err
+	}
+	defer file.Close()
+	h := NewHasher()
+	if _, err = io.Copy(h, file); err != nil {
+		return Sums{}, err
+	}
+	return h.Sums(), nil
+}
+
+// Range hashes size bytes of the file at path starting at start.
+func Range(path string, start, size int64) (Sums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Sums{}, err
+	}
+	defer file.Close()
+	h := NewHasher()
+	n, err := io.Copy(h, io.NewSectionReader(file, start, size))
+	if err != nil {
+		return Sums{}, err
+	}
+	if n != size {
+		return Sums{}, io.ErrUnexpectedEOF
+	}
+	return h.Sums(), nil
+}
+
+// Compare checks the sums present in both, it fails with ErrMismatch on the
+// first difference and reports whether anything was compared at all.
+func (s Sums) Compare(other Sums) (bool, error) {
+	compared := false
+	if len(s.MD5) > 0 && len(other.MD5) > 0 {
+		if !strings.EqualFold(s.MD5, other.MD5) {
+			return true, ErrMismatch
+		}
+		compared = true
+	}
+	if len(s.SHA256) > 0 && len(other.SHA256) > 0 {
+		if !strings.EqualFold(s.SHA256, other.SHA256) {
+			return true, ErrMismatch
+		}
+		compared = true
+	}
+	return compared, nil
+}
+
+var md5Rgx = regexp.MustCompile(`(?i)\b[0-9a-f]{32}\b`)
+var sha256Rgx = regexp.MustCompile(`(?i)\b[0-9a-f]{64}\b`)
+
+// Parse reads a checksum file in md5sum/sha256sum format or a bare digest.
+// The digests are taken from the line naming the file name, a file holding a
+// single digest is trusted whatever it names.
+func Parse(data []byte, name string) Sums {
+	return Sums{
+		MD5:    digest(data, md5Rgx, name),
+		SHA256: digest(data, sha256Rgx, name),
+	}
+}
+
+// digest returns the digest of a "<digest>  <name>" line naming name, or the
+// only digest of the file.
+func digest(data []byte, rgx *regexp.Regexp, name string) string {
+	found := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		d := rgx.FindString(line)
+		if len(d) == 0 {
+			continue
+		}
+		found = append(found, d)
+		// a * in front of the name marks a file hashed in binary mode
+		named := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(line, d)), "*")
+		if strings.HasPrefix(line, d) && path.Base(filepath.ToSlash(named)) == name {
+			return strings.ToLower(d)
+		}
+	}
+	if len(found) == 1 {
+		return strings.ToLower(found[0])
+	}
+	return ""
+}
+
+// Published fetches the checksum files a mirror publishes next to link, e.g.
+// libgen_2023-09-05.rar.md5. Missing files are not an error, the returned
+// sums are simply empty.
+func Published(ctx context.Context, link string) Sums {
+	sums := Sums{}
+	base := strings.TrimSuffix(link, ".rar")
+	for _, candidate := range []string{link + ".md5", base + ".md5", link + ".sha256", base + ".sha256"} {
+		if strings.HasSuffix(candidate, ".md5") && len(sums.MD5) > 0 {
+			continue
+		}
+		if strings.HasSuffix(candidate, ".sha256") && len(sums.SHA256) > 0 {
+			continue
+		}
+		res, err := utils.GetResponse(ctx, candidate, nil)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+		res.Body.Close()
+		if err != nil {
+			continue
+		}
+		parsed := Parse(data, path.Base(link))
+		if strings.HasSuffix(candidate, ".md5") {
+			sums.MD5 = parsed.MD5
+		} else {
+			sums.SHA256 = parsed.SHA256
+		}
+	}
+	return sums
+}
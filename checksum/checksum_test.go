@@ -0,0 +1,32 @@
+package checksum
+
+import "testing"
+
+const (
+	md5A    = "0123456789abcdef0123456789abcdef"
+	md5B    = "fedcba9876543210fedcba9876543210"
+	sha256A = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Sums
+	}{
+		{"bare digest", md5A + "\n", Sums{MD5: md5A}},
+		{"upper case", "0123456789ABCDEF0123456789ABCDEF", Sums{MD5: md5A}},
+		{"single line", md5A + "  other.rar\n", Sums{MD5: md5A}},
+		{"sha256", sha256A + "  libgen_2023-09-05.rar", Sums{SHA256: sha256A}},
+		{"listing", md5B + "  fiction_2023-09-05.rar\n" + md5A + "  libgen_2023-09-05.rar\n", Sums{MD5: md5A}},
+		{"binary mode", md5B + " *fiction_2023-09-05.rar\r\n" + md5A + " *libgen_2023-09-05.rar\r\n", Sums{MD5: md5A}},
+		{"path", md5B + "  dbdumps/fiction_2023-09-05.rar\n" + md5A + "  dbdumps/libgen_2023-09-05.rar\n", Sums{MD5: md5A}},
+		{"not listed", md5A + "  fiction_2023-09-05.rar\n" + md5B + "  scimag_2023-09-05.rar\n", Sums{}},
+		{"empty", "", Sums{}},
+	}
+	for _, test := range tests {
+		if got := Parse([]byte(test.data), "libgen_2023-09-05.rar"); got != test.want {
+			t.Errorf("%s: Parse = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
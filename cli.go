@@ -0,0 +1,847 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"libgen/config"
+	"libgen/downloader"
+	"libgen/dumps"
+	"libgen/export"
+	"libgen/hooks"
+	"libgen/importer"
+	"libgen/manifest"
+	"libgen/mirrors"
+	"libgen/ratelimit"
+	"libgen/snapshot"
+	"libgen/utils"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(ctx context.Context, args []string) int
+}
+
+var commands = []command{
+	{"list", "", "show the dumps available on the mirror with their date and size", runList},
+	{"download", "[dump]", "download the given dump or the latest dump of every selected family", runDownload},
+	{"verify", "<file>", "compare a downloaded dump or its parts with the mirror", runVerify},
+	{"merge", "<file>", "merge the downloaded parts of a dump", runMerge},
+	{"extract", "<file>", "unpack a downloaded dump into its family directory", runExtract},
+	{"import", "<kind> <file> [target]", "stream a downloaded dump into a database without unpacking it (" + strings.Join(importer.Kinds(), ", ") + ")", runImport},
+	{"export", "[flags] <file> [dir]", "write the tables of a downloaded dump as " + strings.Join(export.Formats, " or ") + " files, see export -h", runExport},
+	{"apply", "<kind> <changeset> [target]", "update a catalogue imported with import sqlite or postgres with a changeset of diff", runApply},
+	{"diff", "<file>", "index the records of a downloaded dump and write what changed since the previous snapshot", runDiff},
+	{"watch", "[flags]", "keep running and download every new dump on a schedule, see watch -h", runWatch},
+	{"clean", "", "remove leftover parts and the dumps the retention policy no longer keeps", runClean},
+	{"status", "", "show the local state of the selected families", runStatus},
+}
+
+var families = []*dumps.Family{dumps.Libgen}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [args]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(out, "Without a command the latest dump of every selected family is downloaded once.")
+	fmt.Fprintln(out, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-18s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.usage)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func loadConfig() {
+	mirrorFlag := flag.String("mirror", "", "comma separated list of dump mirrors, tried in order")
+	familyFlag := flag.String("family", "", "comma separated list of dump families to keep up to date ("+strings.Join(dumps.Names(), ", ")+")")
+	flag.StringVar(&AssetDir, "asset", "", "directory the dumps are downloaded to (default \"asset\" next to the executable)")
+	partSize := flag.Int64("part-size", PartSize/(1024*1024), "size of a download part in MiB")
+	direct := flag.Bool("direct", false, "write parts straight into the dump file instead of merging part files")
+	flag.IntVar(&Concurrency, "concurrency", Concurrency, "parts downloaded at once from every mirror when a download starts")
+	minConcurrency := flag.Int("min-concurrency", 0, "fewest parts downloaded at once from every mirror when it struggles (default "+strconv.Itoa(MinConcurrency)+")")
+	maxConcurrency := flag.Int("max-concurrency", 0, "most parts downloaded at once from every mirror when it keeps up (default "+strconv.Itoa(MaxConcurrency)+")")
+	autoExtract := flag.Bool("extract", false, "unpack every dump once it is downloaded and verified")
+	changes := flag.Bool("changes", false, "write a changeset against the previous snapshot for every downloaded dump")
+	pgSchema := flag.String("pg-schema", "", "schema import postgres publishes the catalogue in (default \""+importer.PostgresSchema+"\")")
+	importWorkers := flag.Int("import-workers", 0, "tables indexed at once by import postgres (default "+strconv.Itoa(importer.Workers)+")")
+	probeFlag := flag.String("probe", "", "url requested to check the network is up, \""+utils.ProbeMirror+"\" to probe the mirrors or \""+utils.ProbeDisabled+"\" (default "+utils.DefaultProbe+")")
+	flag.StringVar(&metricsAddr, "metrics", "", "address serving the retry counters as JSON on /debug/vars, e.g. localhost:9090")
+	flag.StringVar(&limitFlag, "limit", "", "bandwidth shared by the downloads, e.g. 1MB or 512KB, overrides the limit in the config file")
+	keep := flag.Int("keep", 0, "dumps of every family kept once a newer one is verified, the newest included (default "+strconv.Itoa(Retention.Keep)+")")
+	keepDays := flag.Int("keep-days", 0, "also keep the dumps published in the last N days")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *partSize <= 0 || Concurrency <= 0 {
+		fmt.Println("part-size and concurrency must be positive")
+		os.Exit(2)
+	}
+	PartSize = *partSize * 1024 * 1024
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Failed to read %s: %v\n", config.GetConfigFile(), err)
+	}
+	if cfg.MirrorCooldown > 0 {
+		mirrors.Cooldown = time.Second * time.Duration(cfg.MirrorCooldown)
+	}
+	if len(*mirrorFlag) > 0 {
+		cfg.Mirrors = config.SplitList(*mirrorFlag)
+	}
+	if len(cfg.Mirrors) > 0 {
+		mirrors.Set(cfg.Mirrors)
+	}
+	DirectWrite = *direct || cfg.Direct
+	AutoExtract = *autoExtract || cfg.Extract
+	Changesets = *changes || cfg.Changesets
+	if len(*pgSchema) > 0 {
+		cfg.PostgresSchema = *pgSchema
+	}
+	if len(cfg.PostgresSchema) > 0 {
+		importer.PostgresSchema = cfg.PostgresSchema
+	}
+	if *minConcurrency > 0 {
+		cfg.MinConcurrency = *minConcurrency
+	}
+	if *maxConcurrency > 0 {
+		cfg.MaxConcurrency = *maxConcurrency
+	}
+	if cfg.MinConcurrency > 0 {
+		MinConcurrency = cfg.MinConcurrency
+	}
+	if cfg.MaxConcurrency > 0 {
+		MaxConcurrency = cfg.MaxConcurrency
+	}
+	if MinConcurrency > MaxConcurrency {
+		fmt.Printf("min-concurrency %d is above max-concurrency %d\n", MinConcurrency, MaxConcurrency)
+		os.Exit(2)
+	}
+	if Concurrency < MinConcurrency {
+		Concurrency = MinConcurrency
+	} else if Concurrency > MaxConcurrency {
+		Concurrency = MaxConcurrency
+	}
+	if *importWorkers > 0 {
+		cfg.ImportWorkers = *importWorkers
+	}
+	if cfg.ImportWorkers > 0 {
+		importer.Workers = cfg.ImportWorkers
+	}
+	if *keep > 0 || *keepDays > 0 {
+		cfg.Retention = config.Retention{Keep: *keep, Days: *keepDays}
+	}
+	if cfg.Retention.IsSet() || len(cfg.Retention.Families) > 0 {
+		if !cfg.Retention.IsSet() {
+			cfg.Retention.Keep = Retention.Keep
+		}
+		Retention = cfg.Retention
+	}
+	if len(*probeFlag) > 0 {
+		cfg.Connectivity.Probe = *probeFlag
+	}
+	if err := setProbe(cfg.Connectivity); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := setBandwidth(cfg.Bandwidth); err != nil {
+		fmt.Printf("Invalid bandwidth limit: %v\n", err)
+		os.Exit(2)
+	}
+	if err := hooks.Set(cfg.Hooks); err != nil {
+		fmt.Printf("Invalid hooks in %s: %v\n", config.GetConfigFile(), err)
+		os.Exit(2)
+	}
+	if len(cfg.Watch.Schedule) > 0 {
+		Watch.Schedule = cfg.Watch.Schedule
+	}
+	Watch.Then = cfg.Watch.Then
+	if len(*familyFlag) > 0 {
+		cfg.Families = config.SplitList(*familyFlag)
+	}
+	if len(cfg.Families) > 0 {
+		selected := make([]*dumps.Family, 0, len(cfg.Families))
+		for _, name := range cfg.Families {
+			family, err := dumps.Get(name)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			selected = append(selected, family)
+		}
+		families = selected
+	}
+}
+func main() {
+	loadConfig()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// the next signal kills the process right away
+		stop()
+		fmt.Println("Interrupted, saving progress, interrupt again to quit right away")
+	}()
+	go reloadConfig(ctx)
+	if len(metricsAddr) > 0 {
+		go serveMetrics(metricsAddr)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		os.Exit(exitStatus(ctx, runUpdate(ctx)))
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			os.Exit(exitStatus(ctx, cmd.run(ctx, args[1:])))
+		}
+	}
+	fmt.Printf("Unknown command %q\n\n", args[0])
+	usage()
+	os.Exit(2)
+}
+
+// setProbe applies the connectivity settings, the defaults stay for those
+// left out.
+func setProbe(c config.Connectivity) error {
+	p := utils.Probe{Target: c.Probe, Timeout: utils.DefaultProbeTimeout, Cache: utils.DefaultProbeCache}
+	if c.Timeout > 0 {
+		p.Timeout = time.Second * time.Duration(c.Timeout)
+	} else if c.Timeout < 0 {
+		p.Timeout = 0
+	}
+	if c.Cache > 0 {
+		p.Cache = time.Second * time.Duration(c.Cache)
+	}
+	p.Mirrors = func() []string {
+		urls := []string{}
+		for _, mirror := range mirrors.Available() {
+			urls = append(urls, mirror.Url)
+		}
+		return urls
+	}
+	return utils.SetProbe(p)
+}
+
+// metricsAddr is the address the expvar counters are served on, e.g. the
+// attempts and retries of every kind of request.
+var metricsAddr = ""
+
+func serveMetrics(addr string) {
+	// expvar registers /debug/vars on the default mux
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		fmt.Printf("Failed to serve metrics on %s: %v\n", addr, err)
+	}
+}
+
+// limitFlag overrides the bandwidth limit of the config file, the schedule
+// still applies.
+var limitFlag = ""
+
+func setBandwidth(policy ratelimit.Policy) error {
+	if len(limitFlag) > 0 {
+		policy.Limit = limitFlag
+	}
+	return ratelimit.Set(policy)
+}
+
+// reloadConfig applies the bandwidth policy and the hooks again whenever the
+// config file changes and tells when the limit in effect changes.
+func reloadConfig(ctx context.Context) {
+	file := config.GetConfigFile()
+	modified := modTime(file)
+	rate := ratelimit.Rate(time.Now())
+	for utils.Sleep(ctx, time.Second*10) == nil {
+		if m := modTime(file); !m.Equal(modified) {
+			modified = m
+			cfg, err := config.Load()
+			if err != nil {
+				fmt.Printf("Failed to reload %s: %v\n", file, err)
+			} else {
+				if err = setBandwidth(cfg.Bandwidth); err != nil {
+					fmt.Printf("Failed to reload the bandwidth limit from %s: %v\n", file, err)
+				}
+				if err = hooks.Set(cfg.Hooks); err != nil {
+					fmt.Printf("Failed to reload the hooks from %s: %v\n", file, err)
+				}
+			}
+		}
+		if current := ratelimit.Rate(time.Now()); current != rate {
+			rate = current
+			if rate > 0 {
+				fmt.Printf("Bandwidth limited to %s/s\n", utils.FormatBytes(rate))
+			} else {
+				fmt.Println("Bandwidth no longer limited")
+			}
+		}
+	}
+}
+
+func modTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// exitStatus waits for the hooks still running and returns status, or 130
+// when the command was interrupted.
+func exitStatus(ctx context.Context, status int) int {
+	hooks.Wait()
+	if ctx.Err() != nil {
+		fmt.Println("Stopped, run the command again to resume")
+		return 130
+	}
+	return status
+}
+
+// locked is set once the process holds the instance lock, the commands run
+// by watch lock it again.
+var locked = false
+
+func lockInstance() bool {
+	if locked {
+		return true
+	}
+	if !utils.FirstInstance() {
+		fmt.Println("Another instance is running")
+		return false
+	}
+	locked = true
+	return true
+}
+
+// findFamily returns the family a dump name belongs to.
+func findFamily(name string) *dumps.Family {
+	for _, family := range dumps.Families {
+		if family.Match(name) {
+			return family
+		}
+	}
+	return nil
+}
+
+// resolveDumpFile turns a dump name into its path in the family directory,
+// existing paths are returned as is.
+func resolveDumpFile(arg string) (string, *dumps.Family, error) {
+	family := findFamily(filepath.Base(arg))
+	if utils.Exists(arg) {
+		abs, err := filepath.Abs(arg)
+		return abs, family, err
+	}
+	if family == nil {
+		return "", nil, fmt.Errorf("%s is not a known dump", arg)
+	}
+	name := filepath.Base(arg)
+	if len(filepath.Ext(name)) == 0 {
+		name += ".rar"
+	}
+	return filepath.Join(GetFamilyDir(family), name), family, nil
+}
+
+// downloadedDump resolves a dump name to a dump that is fully downloaded.
+func downloadedDump(arg string) (string, error) {
+	file, _, err := resolveDumpFile(arg)
+	if err != nil {
+		return "", err
+	}
+	if !utils.Exists(file) {
+		return "", fmt.Errorf("%s has not been downloaded", file)
+	}
+	if m, err := manifest.Load(manifest.PathFor(file)); err == nil && !m.Complete {
+		return "", fmt.Errorf("%s is not complete yet", file)
+	}
+	return file, nil
+}
+
+// remoteDump returns the link and size of a dump name on the first mirror
+// serving it.
+func remoteDump(ctx context.Context, name string) (string, int64, error) {
+	var err error
+	for _, mirror := range mirrors.Available() {
+		link := mirrors.Link(mirror.Url, name)
+		headers, e := downloader.GetHeaders(ctx, link)
+		if e == nil {
+			mirrors.MarkHealthy(link)
+			return link, headers.Size, nil
+		}
+		if ctx.Err() != nil {
+			return "", 0, ctx.Err()
+		}
+		mirrors.MarkDown(link)
+		err = e
+	}
+	if err == nil {
+		err = fmt.Errorf("no mirror serves %s", name)
+	}
+	return "", 0, err
+}
+
+// runUpdate downloads the latest dump of every family once, families whose
+// signal file exists are skipped.
+func runUpdate(ctx context.Context) int {
+	if !lockInstance() {
+		time.Sleep(time.Second * 10)
+		return 1
+	}
+	for _, family := range families {
+		signalFile := GetDownloadedSignalFile(family)
+		if utils.Exists(signalFile) {
+			continue
+		}
+		completed := Start(ctx, family)
+		for !completed && utils.Sleep(ctx, time.Second*10) == nil {
+			completed = Start(ctx, family)
+		}
+		if completed {
+			utils.WriteFile(signalFile, []byte(""))
+		}
+	}
+	return 0
+}
+
+func runList(ctx context.Context, args []string) int {
+	available, mirror := ListDumps(ctx)
+	if len(mirror) == 0 {
+		fmt.Println("No mirror could be listed")
+		return 1
+	}
+	sizes := make([]int64, len(available))
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, Concurrency)
+	for i, dump := range available {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int, name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			headers, err := downloader.GetHeaders(ctx, mirrors.Link(mirror, name))
+			if err == nil {
+				sizes[idx] = headers.Size
+			}
+		}(i, dump)
+	}
+	wg.Wait()
+
+	fmt.Printf("Dumps on %s\n", mirror)
+	fmt.Printf("%-12s %-10s %12s  %s\n", "FAMILY", "DATE", "SIZE", "NAME")
+	for i, dump := range available {
+		familyName, date := "-", "-"
+		if family := findFamily(dump); family != nil {
+			familyName = family.Name
+			if d, ok := family.Date(dump); ok {
+				date = d.Format(dumps.DateLayout)
+			}
+		}
+		size := "-"
+		if sizes[i] > 0 {
+			size = utils.FormatBytes(sizes[i])
+		}
+		fmt.Printf("%-12s %-10s %12s  %s\n", familyName, date, size, mirrors.Name(dump))
+	}
+	return 0
+}
+
+func runDownload(ctx context.Context, args []string) int {
+	if !lockInstance() {
+		return 1
+	}
+	if len(args) == 0 {
+		status := 0
+		for _, family := range families {
+			if !Start(ctx, family) {
+				fmt.Printf("Failed to download the latest %s dump\n", family.Name)
+				status = 1
+			}
+		}
+		return status
+	}
+	status := 0
+	for _, arg := range args {
+		name := mirrors.Name(arg)
+		if len(filepath.Ext(name)) == 0 {
+			name += ".rar"
+		}
+		family := findFamily(name)
+		if family == nil {
+			fmt.Printf("%s is not a known dump\n", arg)
+			status = 1
+			continue
+		}
+		link, size, err := remoteDump(ctx, name)
+		if err != nil {
+			fmt.Printf("Failed to find %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		if !DownloadDump(ctx, family, link, size) {
+			fmt.Printf("Failed to download %s\n", name)
+			status = 1
+		}
+	}
+	return status
+}
+
+func runVerify(ctx context.Context, args []string) int {
+	if len(args) != 1 {
+		fmt.Println("verify expects a dump file")
+		return 2
+	}
+	file, family, err := resolveDumpFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	link, size, err := remoteDump(ctx, filepath.Base(file))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	partSize := PartSize
+	m, _ := manifest.Load(manifest.PathFor(file))
+	if m != nil {
+		partSize = m.PartSize
+	}
+	ok := false
+	if m != nil && m.Direct && !m.Complete {
+		ok = VerifyPartChecksums(file, m)
+	} else if parts, err := GetSortedParts(file); err == nil && len(parts) > 0 {
+		ok = VerifyPartChecksums(file, m) && VerifyPartsFromNetwork(ctx, link, file, size, partSize)
+	} else if utils.Exists(file) {
+		if ok = utils.GetFileSize(file) == size; ok {
+			sums, err := VerifyChecksums(ctx, link, file, m, true)
+			if len(sums.SHA256) > 0 {
+				fmt.Printf("MD5     %s\nSHA-256 %s\n", sums.MD5, sums.SHA256)
+			}
+			if err != nil {
+				fmt.Println(err)
+				ok = false
+			}
+		}
+		ok = ok && VerifyFileFromNetwork(ctx, link, file, partSize)
+	} else {
+		fmt.Printf("%s has not been downloaded\n", file)
+		return 1
+	}
+	if !ok {
+		fmt.Printf("%s does not match %s\n", file, link)
+		if family != nil {
+			hooks.Fire(ctx, dumpEvent(hooks.VerifyFailed, family, file, link, size))
+		}
+		return 1
+	}
+	fmt.Printf("%s matches %s\n", file, link)
+	return 0
+}
+
+func runMerge(ctx context.Context, args []string) int {
+	if len(args) != 1 {
+		fmt.Println("merge expects a dump file")
+		return 2
+	}
+	if !lockInstance() {
+		return 1
+	}
+	file, _, err := resolveDumpFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := MergeParts(ctx, file); err != nil {
+		fmt.Printf("Failed to merge %s: %v\n", file, err)
+		return 1
+	}
+	CleanDownloadedParts(file)
+	fmt.Printf("Merged %s\n", file)
+	return 0
+}
+
+func runExtract(ctx context.Context, args []string) int {
+	if len(args) != 1 {
+		fmt.Println("extract expects a dump file")
+		return 2
+	}
+	if !lockInstance() {
+		return 1
+	}
+	file, err := downloadedDump(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if _, err := ExtractDump(ctx, file); err != nil {
+		fmt.Printf("Failed to extract %s: %v\n", file, err)
+		return 1
+	}
+	return 0
+}
+
+func runImport(ctx context.Context, args []string) int {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Println("import expects an importer, a dump file and optionally a target")
+		return 2
+	}
+	if !lockInstance() {
+		return 1
+	}
+	file, err := downloadedDump(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	target := importer.DefaultTarget(args[0], file)
+	if len(args) == 3 {
+		target = args[2]
+	}
+	imp, err := importer.New(args[0], target)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := ImportDump(ctx, file, imp); err != nil {
+		fmt.Printf("Failed to import %s: %v\n", file, err)
+		return 1
+	}
+	fmt.Printf("Imported %s\n", file)
+	return 0
+}
+
+func runDiff(ctx context.Context, args []string) int {
+	if len(args) != 1 {
+		fmt.Println("diff expects a dump file")
+		return 2
+	}
+	if !lockInstance() {
+		return 1
+	}
+	file, err := downloadedDump(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := DiffDump(ctx, file); err != nil {
+		fmt.Printf("Failed to diff %s: %v\n", file, err)
+		return 1
+	}
+	return 0
+}
+
+// resolveChangeset turns a dump name into the path of its changeset, existing
+// paths are returned as is.
+func resolveChangeset(arg string) (string, error) {
+	if utils.Exists(arg) {
+		return arg, nil
+	}
+	family := findFamily(filepath.Base(arg))
+	if family == nil {
+		return "", fmt.Errorf("%s is neither a changeset nor a known dump", arg)
+	}
+	path := snapshot.ChangesetPath(GetSnapshotDir(family), family.Find(filepath.Base(arg)))
+	if !utils.Exists(path) {
+		return "", fmt.Errorf("%s has no changeset, run diff first", arg)
+	}
+	return path, nil
+}
+
+func runApply(ctx context.Context, args []string) int {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Println("apply expects an importer, a changeset and optionally a target")
+		return 2
+	}
+	if !lockInstance() {
+		return 1
+	}
+	path, err := resolveChangeset(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	target := ""
+	if len(args) == 3 {
+		target = args[2]
+	}
+	changes, err := snapshot.OpenChangeset(path)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer changes.Close()
+	fmt.Printf("Applying the changes from %s to %s\n", changes.Base, changes.Snapshot)
+	tables, err := importer.Apply(args[0], target, changes)
+	if errors.Is(err, importer.ErrApplied) {
+		fmt.Println(err)
+		return 0
+	}
+	if err != nil {
+		fmt.Printf("Failed to apply %s: %v\n", path, err)
+		return 1
+	}
+	for _, table := range tables {
+		fmt.Printf("%-20s %10d added %10d changed %10d removed\n", table.Table, table.Added, table.Changed, table.Removed)
+	}
+	fmt.Printf("The catalogue reflects %s\n", changes.Snapshot)
+	return 0
+}
+
+// filterList collects the repeated -where flags of export.
+type filterList []export.Filter
+
+func (f *filterList) String() string {
+	list := []string{}
+	for _, filter := range *f {
+		list = append(list, filter.String())
+	}
+	return strings.Join(list, " ")
+}
+
+func (f *filterList) Set(value string) error {
+	filter, err := export.ParseFilter(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, filter)
+	return nil
+}
+
+func runExport(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "output format ("+strings.Join(export.Formats, ", ")+")")
+	tables := flags.String("tables", "", "comma separated list of tables to export (default every table)")
+	columns := flags.String("columns", "", "comma separated list of columns to export, either column or table.column (default every column)")
+	partition := flags.String("partition", "", "comma separated list of columns the files are partitioned by, e.g. Year,Language")
+	filters := filterList{}
+	flags.Var(&filters, "where", "keep the rows matching column=value, other operators are != > >= < <= and ~ (contains), repeatable")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [flags] <file> [dir]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(flags.Output(), "The files are written to dir/<table>/[<column>=<value>/]part-NNNN.<format>, dir defaults to <dump>-export next to the dump.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("export expects a dump file and optionally an output directory")
+		return 2
+	}
+	file, err := downloadedDump(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	dir := utils.RemoveExt(file) + "-export"
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	exp, err := export.New(export.Options{
+		Format:    *format,
+		Dir:       dir,
+		Tables:    config.SplitList(*tables),
+		Columns:   config.SplitList(*columns),
+		Partition: config.SplitList(*partition),
+		Filters:   filters,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := ExportDump(ctx, file, exp); err != nil {
+		fmt.Printf("Failed to export %s: %v\n", file, err)
+		return 1
+	}
+	fmt.Printf("Exported %s to %s\n", file, dir)
+	return 0
+}
+
+func runClean(ctx context.Context, args []string) int {
+	if !lockInstance() {
+		return 1
+	}
+	// only the parts downloads leave, <dump>-part-<N>.tmp and .rar, are
+	// removed, other files may belong to other tools
+	rgx := regexp.MustCompile(`^(.+)-part-\d+\.(tmp|rar)$`)
+	for _, family := range families {
+		removed := 0
+		dir := GetFamilyDir(family)
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			match := rgx.FindStringSubmatch(entry.Name())
+			if entry.IsDir() || match == nil || family.Find(match[1]) != match[1] {
+				continue
+			}
+			if os.Remove(filepath.Join(dir, entry.Name())) == nil {
+				removed++
+			}
+		}
+		fmt.Printf("%s: removed %d file(s)\n", family.Name, removed)
+		if pruned := PruneDumps(family); len(pruned) > 0 {
+			fmt.Printf("%s: removed %s\n", family.Name, strings.Join(pruned, ", "))
+		}
+	}
+	return 0
+}
+
+func runStatus(ctx context.Context, args []string) int {
+	rgx := regexp.MustCompile(`-part-\d+\.rar$`)
+	for _, family := range families {
+		dir := GetFamilyDir(family)
+		fmt.Printf("%s (%s)\n", family.Name, dir)
+		last := GetLastDowloadedDump(family)
+		if len(last) == 0 {
+			fmt.Println("  nothing downloaded")
+			continue
+		}
+		parts, partBytes := 0, int64(0)
+		for _, inf := range utils.GetInfosFromDir(dir) {
+			if !inf.Info.IsDir() && rgx.MatchString(inf.FullPath) && strings.HasPrefix(inf.Info.Name(), family.Find(last)) {
+				parts++
+				partBytes += inf.Info.Size()
+			}
+		}
+		fmt.Printf("  dump:       %s\n", last)
+		file := filepath.Join(dir, family.Find(last)+".rar")
+		if m, err := manifest.Load(manifest.PathFor(file)); err == nil {
+			done, doneBytes := m.Done()
+			fmt.Printf("  source:     %s\n", m.Url)
+			fmt.Printf("  progress:   %d/%d parts (%s/%s)\n", done, len(m.Parts), utils.FormatBytes(doneBytes), utils.FormatBytes(m.Size))
+			if m.Direct && !m.Complete {
+				fmt.Printf("  parts:      %s\n", m.Bitmap())
+			}
+			fmt.Printf("  complete:   %t\n", m.Complete)
+		} else if parts > 0 {
+			fmt.Printf("  parts:      %d (%s)\n", parts, utils.FormatBytes(partBytes))
+		}
+		if utils.Exists(file) {
+			fmt.Printf("  merged:     %s\n", utils.FormatBytes(utils.GetFileSize(file)))
+		}
+		names, _ := LocalDumps(family)
+		kept := []string{}
+		for _, name := range names {
+			if name != family.Find(last) && IsDumpComplete(family, name) {
+				kept = append(kept, name)
+			}
+		}
+		if len(kept) > 0 {
+			fmt.Printf("  kept:       %s\n", strings.Join(kept, ", "))
+		}
+		fmt.Printf("  signalled:  %t\n", utils.Exists(GetDownloadedSignalFile(family)))
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"libgen/hooks"
+	"libgen/ratelimit"
+	"libgen/utils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config is read from config.json next to the executable, every field is
+// optional and environment variables take precedence over the file.
+type Config struct {
+	// Mirrors are the dump directory listings in order of preference.
+	Mirrors []string `json:"mirrors"`
+	// MirrorCooldown is how long in seconds a dead mirror is skipped.
+	MirrorCooldown int `json:"mirror_cooldown"`
+	// Families are the dump families kept up to date, e.g. libgen, fiction.
+	Families []string `json:"families"`
+	// Direct writes parts straight into the dump file, see the -direct flag.
+	Direct bool `json:"direct"`
+	// Extract unpacks every dump once it is downloaded, see the -extract flag.
+	Extract bool `json:"extract"`
+	// PostgresSchema is the schema import postgres publishes the catalogue in.
+	PostgresSchema string `json:"postgres_schema"`
+	// MinConcurrency and MaxConcurrency bound the parts downloaded at once
+	// from every mirror, see the -min-concurrency and -max-concurrency flags.
+	MinConcurrency int `json:"min_concurrency"`
+	MaxConcurrency int `json:"max_concurrency"`
+	// Connectivity is the check run before requests to the mirrors, see the
+	// -probe flag.
+	Connectivity Connectivity `json:"connectivity"`
+	// Bandwidth caps the bandwidth of the downloads, by time of day if need
+	// be, see the -limit flag. Changes apply without a restart.
+	Bandwidth ratelimit.Policy `json:"bandwidth"`
+	// ImportWorkers is the number of tables indexed at once by an import.
+	ImportWorkers int `json:"import_workers"`
+	// Changesets diffs every dump against the previous one, see the -changes
+	// flag.
+	Changesets bool `json:"changesets"`
+	// Retention is how many dumps are kept once a newer one is downloaded,
+	// see the -keep and -keep-days flags.
+	Retention Retention `json:"retention"`
+	// Watch is the schedule of the watch command and what it runs on every
+	// new dump.
+	Watch Watch `json:"watch"`
+	// Hooks run shell commands or post to webhooks when something happens
+	// to a dump.
+	Hooks []hooks.Hook `json:"hooks"`
+}
+
+// Connectivity configures the probe telling whether the network is up.
+// Probe is a url, "mirror" to probe the mirrors themselves or "disabled",
+// Timeout is how long in seconds a request waits for the network, -1 for as
+// long as it takes, and Cache how long in seconds a successful probe is
+// trusted.
+type Connectivity struct {
+	Probe   string `json:"probe"`
+	Timeout int    `json:"timeout"`
+	Cache   int    `json:"cache"`
+}
+
+// Watch configures the watch command. Schedule is five cron fields or one of
+// @hourly, @daily, @weekly, @monthly and @every <duration>, Then lists the
+// commands run on every new dump, e.g. "extract" or "import sqlite".
+type Watch struct {
+	Schedule string   `json:"schedule"`
+	Then     []string `json:"then"`
+}
+
+// Retention keeps the newest Keep dumps of a family and those published in
+// the last Days days, the newest complete dump is always kept. Families
+// overrides the policy of some families, e.g. {"scimag": {"keep": 1}}.
+type Retention struct {
+	Keep     int                  `json:"keep"`
+	Days     int                  `json:"days"`
+	Families map[string]Retention `json:"families,omitempty"`
+}
+
+// IsSet reports whether a count or an age is configured.
+func (r Retention) IsSet() bool {
+	return r.Keep > 0 || r.Days > 0
+}
+
+// For returns the policy of a family.
+func (r Retention) For(family string) Retention {
+	for name, policy := range r.Families {
+		if strings.EqualFold(name, family) && policy.IsSet() {
+			return policy
+		}
+	}
+	return Retention{Keep: r.Keep, Days: r.Days}
+}
+
+const (
+	EnvConfig   = "LIBGEN_CONFIG"
+	EnvMirrors  = "LIBGEN_MIRRORS"
+	EnvFamilies = "LIBGEN_FAMILIES"
+)
+
+func GetConfigFile() string {
+	if file := os.Getenv(EnvConfig); len(file) > 0 {
+		return file
+	}
+	return filepath.Join(utils.GetBaseDirectory(), "config.json")
+}
+
+// Load reads the config file, a missing file is not an error.
+func Load() (*Config, error) {
+	cfg := Config{}
+	file := GetConfigFile()
+	if utils.Exists(file) {
+		data, err := utils.ReadFile(file)
+		if err != nil {
+			return &cfg, err
+		}
+		if err = json.Unmarshal(data, &cfg); err != nil {
+			return &cfg, err
+		}
+	}
+	if mirrors := os.Getenv(EnvMirrors); len(mirrors) > 0 {
+		cfg.Mirrors = SplitList(mirrors)
+	}
+	if families := os.Getenv(EnvFamilies); len(families) > 0 {
+		cfg.Families = SplitList(families)
+	}
+	return &cfg, nil
+}
+
+// SplitList splits a comma or space separated list.
+func SplitList(list string) []string {
+	return utils.RemoveEmptyFromSlice(strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';'
+	}))
+}
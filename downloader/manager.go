@@ -1,10 +1,14 @@
 package downloader
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"libgen/mimes"
+	"libgen/ratelimit"
+	"libgen/retry"
 	"libgen/utils"
 	"mime"
 	"net/http"
@@ -17,8 +21,52 @@ import (
 )
 
 type Headers struct {
-	Size int64
-	Name string
+	Size         int64
+	Name         string
+	ETag         string
+	LastModified string
+	AcceptRanges string
+}
+
+// ErrEntityChanged is returned when a ranged request is answered with the
+// whole file because the remote file no longer matches its validator.
+var ErrEntityChanged = errors.New("remote file changed")
+
+// Validator returns the value to send in If-Range, weak ETags are not
+// allowed there so Last-Modified is used instead.
+func (h *Headers) Validator() string {
+	if len(h.ETag) > 0 && !strings.HasPrefix(h.ETag, "W/") {
+		return h.ETag
+	}
+	return h.LastModified
+}
+
+// RangeHeaders returns the headers of a request for bytes start to end,
+// end < 0 requests the rest of the file.
+func RangeHeaders(start, end int64, validator string) map[string]string {
+	hd := map[string]string{}
+	if end < 0 {
+		hd["Range"] = fmt.Sprintf("bytes=%d-", start)
+	} else {
+		hd["Range"] = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	if len(validator) > 0 {
+		hd["If-Range"] = validator
+	}
+	return hd
+}
+
+// CheckRange checks the response to a ranged request sent with
+// RangeHeaders. A full response means the validator no longer matches, or
+// the server ignores ranges altogether when it still matches.
+func CheckRange(res *http.Response, validator string) error {
+	if res.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+	if len(validator) > 0 && validator != res.Header.Get("ETag") && validator != res.Header.Get("Last-Modified") {
+		return ErrEntityChanged
+	}
+	return errors.New("server does not support ranged requests")
 }
 
 var lck sync.Mutex
@@ -41,12 +89,16 @@ type DownloadItem struct {
 	Link   string
 	Status DownloadStatus
 
-	stopped           bool
 	DownloadDirectory string
 	Size              int64
 	Downloading       bool
 	dlLck             *sync.Mutex
 	dst               string
+	// stopLck guards stopped and cancel, Stop is called from other
+	// goroutines than the one downloading
+	stopLck *sync.Mutex
+	stopped bool
+	cancel  context.CancelFunc
 }
 type ProgressState struct {
 	Id         int    `json:"id"`
@@ -92,26 +144,25 @@ func GetName(resource string, ext *string) string {
 	return utils.ReplaceInvalidFileChars(name)
 }
 
-func GetHeaders(uri string) (*Headers, error) {
+func GetHeaders(ctx context.Context, uri string) (*Headers, error) {
 	hd := Headers{}
-	utils.WaitForConnection()
-	res, err := utils.GetResponse(uri, nil)
-	var retrys = 0
-	for err != nil {
-
-		res, err = utils.GetResponse(uri, nil)
-		if err == nil || retrys > 5 {
-			break
+	var res *http.Response
+	err := retry.Do(ctx, "headers", uri, func(attempt int) error {
+		if err := utils.WaitForConnection(ctx); err != nil {
+			return err
 		}
-		utils.WaitForConnection()
-		retrys++
-
-	}
+		var err error
+		res, err = utils.GetResponse(ctx, uri, nil)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 	hd.Size = res.ContentLength
+	hd.ETag = res.Header.Get("ETag")
+	hd.LastModified = res.Header.Get("Last-Modified")
+	hd.AcceptRanges = res.Header.Get("Accept-Ranges")
 
 	name := res.Header.Get("Content-Disposition")
 
@@ -136,7 +187,7 @@ func GetHeaders(uri string) (*Headers, error) {
 	return &hd, nil
 }
 
-func CanResume(uri string) bool {
+func CanResume(ctx context.Context, uri string) bool {
 	sz := []int64{0, 0}
 	wg := sync.WaitGroup{}
 	for i := 0; i < 2; i++ {
@@ -149,7 +200,7 @@ func CanResume(uri string) bool {
 					"Range": "bytes=1024-",
 				}
 			}
-			res, err := utils.GetResponse(uri, &hd)
+			res, err := utils.GetResponse(ctx, uri, &hd)
 			if err == nil {
 				defer res.Body.Close()
 				sz[idx] = res.ContentLength
@@ -162,7 +213,9 @@ func CanResume(uri string) bool {
 
 }
 
-func Download(link, destFile string) (DownloadItem, error) {
+// Download downloads link to destFile and prints its progress, the download
+// stops when ctx is done and resumes from the partial file next time.
+func Download(ctx context.Context, link, destFile string) (DownloadItem, error) {
 
 	defer func() {
 		recover()
@@ -171,8 +224,9 @@ func Download(link, destFile string) (DownloadItem, error) {
 	complete := make(chan error)
 	finished := false
 	dl := DownloadItem{
-		Link:  link,
-		dlLck: &sync.Mutex{},
+		Link:    link,
+		dlLck:   &sync.Mutex{},
+		stopLck: &sync.Mutex{},
 	}
 	defer close(complete)
 	wg := sync.WaitGroup{}
@@ -198,7 +252,7 @@ func Download(link, destFile string) (DownloadItem, error) {
 		}
 	}()
 	go func() {
-		complete <- dl.Download(destFile)
+		complete <- dl.Download(ctx, destFile)
 	}()
 	err := <-complete
 
@@ -209,12 +263,30 @@ func Download(link, destFile string) (DownloadItem, error) {
 
 	return dl, err
 }
+
+// Stop stops the download, the partial file is kept.
 func (item *DownloadItem) Stop() {
+	item.stopLck.Lock()
+	defer item.stopLck.Unlock()
 	item.stopped = true
+	if item.cancel != nil {
+		item.cancel()
+	}
 }
 func (item *DownloadItem) Stopped() bool {
+	item.stopLck.Lock()
+	defer item.stopLck.Unlock()
 	return item.stopped
 }
+
+// interrupted returns nil when the download was stopped by Stop and err,
+// the error of the context, otherwise.
+func (item *DownloadItem) interrupted(err error) error {
+	if item.Stopped() {
+		return nil
+	}
+	return err
+}
 func (item *DownloadItem) finish() error {
 
 	item.Status.Progress = 100
@@ -224,23 +296,51 @@ func (item *DownloadItem) finish() error {
 		os.MkdirAll(item.DownloadDirectory, 0666)
 	}
 	os.Remove(destFile)
+	os.Remove(item.validatorFile())
 	return os.Rename(item.dst, destFile)
 }
-func (item *DownloadItem) Download(destFile string) error {
+
+// validatorFile holds the ETag or Last-Modified of the remote file the
+// partial file was started with.
+func (item *DownloadItem) validatorFile() string {
+	return item.dst + ".validator"
+}
+
+func (item *DownloadItem) savedValidator() string {
+	data, err := utils.ReadFile(item.validatorFile())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Download downloads the item to destFile until it is complete, Stop is
+// called or ctx is done. An interrupted download is synced to disk and
+// resumed by the next call.
+func (item *DownloadItem) Download(ctx context.Context, destFile string) error {
 	item.dlLck.Lock()
 	item.Downloading = true
 	defer func() {
 		item.Downloading = false
 		item.dlLck.Unlock()
 	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	item.stopLck.Lock()
 	item.stopped = false
+	item.cancel = cancel
+	item.stopLck.Unlock()
 	if destFile != "" {
 		item.Name = utils.ReplaceInvalidFileChars(filepath.Base(destFile))
 	}
 
-	utils.WaitForConnection()
-	h, err := GetHeaders(item.Link)
+	if err := utils.WaitForConnection(ctx); err != nil {
+		return item.interrupted(err)
+	}
+	h, err := GetHeaders(ctx, item.Link)
+	validator := ""
 	if err == nil && h != nil {
+		validator = h.Validator()
 		for item.Name == "" {
 			item.Name = h.Name
 		}
@@ -248,7 +348,9 @@ func (item *DownloadItem) Download(destFile string) error {
 			item.Size = h.Size
 		}
 	}
-	time.Sleep(time.Second)
+	if err := utils.Sleep(ctx, time.Second); err != nil {
+		return item.interrupted(err)
+	}
 
 	if item.DownloadDirectory == "" {
 		item.DownloadDirectory = filepath.Dir(destFile)
@@ -260,12 +362,8 @@ func (item *DownloadItem) Download(destFile string) error {
 		item.Status.Downloaded = inf.Size()
 	}
 
-	for !utils.InternetIsWorking() {
-
-		if item.stopped {
-			return nil
-		}
-		time.Sleep(time.Millisecond * 500)
+	if err := utils.WaitForConnection(ctx); err != nil {
+		return item.interrupted(err)
 	}
 	var resp *http.Response = nil
 	{
@@ -283,43 +381,61 @@ func (item *DownloadItem) Download(destFile string) error {
 
 	}
 	canResume := false
+	// the partial file is only resumed against the validator it was
+	// written with, a fresh one always matches what the server sends now
+	saved := item.savedValidator()
+	if utils.Exists(item.dst) && saved != validator && (len(saved) > 0 || len(validator) > 0) {
+		if len(saved) > 0 {
+			fmt.Printf("%s changed since the partial download, starting over\n", item.Name)
+		} else {
+			fmt.Printf("%s has no record of the file it was started from, starting over\n", item.Name)
+		}
+		os.Remove(item.dst)
+		item.Status.Downloaded = 0
+	}
 	if utils.Exists(item.dst) {
 		if item.Status.Downloaded == item.Size {
 			return item.finish()
 		}
-		if CanResume(item.Link) {
+		if CanResume(ctx, item.Link) {
 			canResume = true
 
-			for i := 0; i < 4; i++ {
-				for !utils.InternetIsWorking() {
-
-					if item.stopped {
-						return nil
-					}
-					time.Sleep(time.Millisecond * 500)
-				}
-				reqH := map[string]string{
-					"Range": fmt.Sprintf("bytes=%d-", item.Status.Downloaded),
-				}
-				resp, err = utils.GetResponse(item.Link, &reqH)
-				if err == nil {
-					break
+			retry.Do(ctx, "resume", item.Link, func(attempt int) error {
+				if err := utils.WaitForConnection(ctx); err != nil {
+					return err
 				}
+				reqH := RangeHeaders(item.Status.Downloaded, -1, saved)
+				resp, err = utils.GetResponse(ctx, item.Link, &reqH)
+				return err
+			})
+			if ctx.Err() != nil {
+				return item.interrupted(ctx.Err())
 			}
 
 		}
 	}
+	if resp != nil && CheckRange(resp, saved) != nil {
+		// the whole file came back, either it changed since the partial
+		// download or ranges are ignored, so start over with this response
+		canResume = false
+	}
 
 	if resp == nil {
 		canResume = false
 		item.Status.Downloaded = 0
-		resp, err = utils.GetResponse(item.Link, nil)
+		resp, err = utils.GetResponse(ctx, item.Link, nil)
 	}
 	if err != nil {
+		if ctx.Err() != nil {
+			return item.interrupted(ctx.Err())
+		}
 		return err
 	}
 	defer resp.Body.Close()
 	item.Size = resp.ContentLength
+	if canResume && resp.ContentLength >= 0 {
+		item.Size += item.Status.Downloaded
+	}
 	var file *os.File
 	var bytesDl int64 = 0
 
@@ -328,6 +444,10 @@ func (item *DownloadItem) Download(destFile string) error {
 	if !canResume {
 		item.Status.Downloaded = 0
 		file, err = os.OpenFile(item.dst, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			received := Headers{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+			err = utils.WriteFile(item.validatorFile(), []byte(received.Validator()))
+		}
 	} else {
 		file, err = os.OpenFile(item.dst, os.O_APPEND|os.O_WRONLY, 0644)
 	}
@@ -336,20 +456,24 @@ func (item *DownloadItem) Download(destFile string) error {
 	}
 
 	start := time.Now()
+	body := ratelimit.Reader(ctx, resp.Body)
 
 	for {
-		ln, err = io.CopyN(file, resp.Body, 2048)
+		ln, err = io.CopyN(file, body, 2048)
 		bytesDl += int64(ln)
-		if item.stopped {
-
-			return nil
-		}
 		item.Status.Downloaded += int64(ln)
+		if ctx.Err() != nil {
+			// what arrived so far is where the next call resumes
+			file.Sync()
+			file.Close()
+			return item.interrupted(ctx.Err())
+		}
 		if err == io.EOF {
 			file.Close()
 			return item.finish()
 		}
 		if err != nil {
+			file.Sync()
 			file.Close()
 			return err
 		}
@@ -0,0 +1,103 @@
+package dumps
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Family is a series of dated dumps published under the same name, e.g.
+// libgen_2023-09-05.rar and libgen_2023-09-12.rar.
+type Family struct {
+	Name string
+	// Pattern matches the dated name of a dump of the family without the
+	// extension, its first group is the date.
+	Pattern *regexp.Regexp
+}
+
+const DateLayout = "2006-01-02"
+
+var (
+	Libgen    = newFamily("libgen", `libgen_`)
+	LibgenNew = newFamily("libgen_new", `libgen_new_`)
+	Compact   = newFamily("compact", `libgen_compact_`)
+	Fiction   = newFamily("fiction", `fiction_`)
+	Scimag    = newFamily("scimag", `scimag_`)
+)
+
+var Families = []*Family{Libgen, LibgenNew, Compact, Fiction, Scimag}
+
+func newFamily(name, prefix string) *Family {
+	return &Family{
+		Name:    name,
+		Pattern: regexp.MustCompile(`(?:^|[/\\])` + regexp.QuoteMeta(prefix) + `(\d{4,}-\d{2,}-\d{2,})`),
+	}
+}
+
+func Get(name string) (*Family, error) {
+	name = strings.TrimSpace(name)
+	for _, f := range Families {
+		if strings.EqualFold(f.Name, name) {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown dump family %q", name)
+}
+
+// Names returns the names of all known families.
+func Names() []string {
+	names := make([]string, 0, len(Families))
+	for _, f := range Families {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// Match reports whether the file or link name is a dump of the family.
+func (f *Family) Match(name string) bool {
+	return f.Pattern.MatchString(name)
+}
+
+// Find returns the dated dump name in name, e.g. libgen_2023-09-05 for
+// libgen_2023-09-05-part-3.rar, or an empty string.
+func (f *Family) Find(name string) string {
+	match := f.Pattern.FindString(name)
+	return strings.TrimLeft(match, `/\`)
+}
+
+// Date returns the snapshot date of a dump of the family.
+func (f *Family) Date(name string) (time.Time, bool) {
+	groups := f.Pattern.FindStringSubmatch(name)
+	if len(groups) < 2 {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(DateLayout, groups[1])
+	return date, err == nil
+}
+
+// Newer reports whether the dump name is dated after the dump than, any dump
+// is newer than an empty or undated one.
+func (f *Family) Newer(name, than string) bool {
+	date, ok := f.Date(name)
+	if !ok {
+		return false
+	}
+	thanDate, ok := f.Date(than)
+	return !ok || date.After(thanDate)
+}
+
+// Latest returns the names of the family in names, newest first.
+func (f *Family) Latest(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		if f.Match(name) {
+			res = append(res, name)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return f.Newer(res[i], res[j])
+	})
+	return res
+}
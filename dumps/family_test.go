@@ -0,0 +1,36 @@
+package dumps
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		name, than string
+		want       bool
+	}{
+		{"libgen_2023-09-12", "libgen_2023-09-05", true},
+		{"libgen_2023-09-05", "libgen_2023-09-12", false},
+		{"libgen_2023-09-05", "libgen_2023-09-05", false},
+		{"libgen_2023-09-05", "", true},
+		// dates compare by value, not by text
+		{"libgen_2024-01-01", "libgen_2023-12-31", true},
+		{"libgen_2023-09-05-part-3.rar", "libgen_2023-09-04.rar", true},
+		{"libgen_2023-13-01", "libgen_2023-09-05", false},
+		{"libgen_new_2023-09-05", "libgen_2023-09-12", false},
+	}
+	for _, test := range tests {
+		if got := Libgen.Newer(test.name, test.than); got != test.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", test.name, test.than, got, test.want)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	names := []string{"libgen_2023-09-05.rar", "fiction_2023-10-01.rar", "libgen_2023-10-01.rar", "libgen_2022-12-31.rar"}
+	want := []string{"libgen_2023-10-01.rar", "libgen_2023-09-05.rar", "libgen_2022-12-31.rar"}
+	if got := Libgen.Latest(names); !reflect.DeepEqual(got, want) {
+		t.Errorf("Latest = %v, want %v", got, want)
+	}
+}
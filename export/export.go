@@ -0,0 +1,352 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"libgen/importer"
+	"libgen/sqldump"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxOpenFiles is the number of partition files kept open at once, the least
+// recently written one is closed first and continued in a new part file.
+var MaxOpenFiles = 128
+
+type Options struct {
+	// Format is jsonl or parquet.
+	Format string
+	Dir    string
+	// Tables to export, every table when empty.
+	Tables []string
+	// Columns to export, either column or table.column. Tables having none of
+	// them are skipped, every column is exported when empty.
+	Columns []string
+	// Partition lists the columns rows are partitioned by, e.g. Year and
+	// Language, tables without them are not partitioned.
+	Partition []string
+	Filters   []Filter
+}
+
+// Formats lists the supported output formats.
+var Formats = []string{"jsonl", "parquet"}
+
+// Kind is how the values of a column are written.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInt
+	KindFloat
+	KindTime
+	KindBytes
+)
+
+// Column is an exported column, Index is its position in the table.
+type Column struct {
+	Name  string
+	Kind  Kind
+	Index int
+}
+
+// rowWriter writes the rows of one partition file.
+type rowWriter interface {
+	Write(values []sqldump.Value) error
+	Close() error
+}
+
+type table struct {
+	name      string
+	skip      bool
+	columns   []Column
+	index     map[string]int
+	width     int
+	filters   []Filter
+	filterIdx []int
+	partition []int
+	partNames []string
+	// mapping maps the values of the last INSERT column list to columns
+	mapping     []int
+	lastColumns *string
+	parts       map[string]int
+	rows        int64
+}
+
+type openFile struct {
+	writer rowWriter
+	used   int64
+}
+
+// Exporter writes the tables of a dump as JSON Lines or Parquet files under
+// Dir/<table>/[<column>=<value>/...]part-NNNN.<format>.
+type Exporter struct {
+	opts   Options
+	tables map[string]*table
+	order  []string
+	open   map[string]*openFile
+	clock  int64
+}
+
+func New(opts Options) (*Exporter, error) {
+	opts.Format = strings.ToLower(opts.Format)
+	if opts.Format != "jsonl" && opts.Format != "parquet" {
+		return nil, fmt.Errorf("unknown format %q, expected one of %s", opts.Format, strings.Join(Formats, ", "))
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		opts:   opts,
+		tables: map[string]*table{},
+		open:   map[string]*openFile{},
+	}, nil
+}
+
+func (e *Exporter) Import(name string, r io.Reader) error {
+	reader := sqldump.NewReader(r)
+	row := []sqldump.Value{}
+	for {
+		dumpRow, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t, err := e.table(dumpRow)
+		if err != nil {
+			return err
+		}
+		if t.skip {
+			continue
+		}
+		if row, err = t.values(dumpRow, row); err != nil {
+			return fmt.Errorf("line %d: %w", reader.Line(), err)
+		}
+		if !t.match(row) {
+			continue
+		}
+		writer, err := e.writer(t, row)
+		if err != nil {
+			return err
+		}
+		if err = writer.Write(row); err != nil {
+			return err
+		}
+		t.rows++
+	}
+}
+
+func (e *Exporter) Close(err error) error {
+	for key, f := range e.open {
+		if closeErr := f.writer.Close(); err == nil {
+			err = closeErr
+		}
+		delete(e.open, key)
+	}
+	return err
+}
+
+func (e *Exporter) Summary() []importer.TableSummary {
+	res := []importer.TableSummary{}
+	for _, key := range e.order {
+		if t := e.tables[key]; !t.skip {
+			res = append(res, importer.TableSummary{Table: t.name, Rows: t.rows})
+		}
+	}
+	return res
+}
+
+// table sets up the columns, filters and partitions of a table on its first
+// row.
+func (e *Exporter) table(row *sqldump.Row) (*table, error) {
+	key := strings.ToLower(row.Table.Name)
+	if t, ok := e.tables[key]; ok {
+		return t, nil
+	}
+	t := &table{name: row.Table.Name, index: map[string]int{}, parts: map[string]int{}}
+	e.tables[key] = t
+	e.order = append(e.order, key)
+	if len(e.opts.Tables) > 0 && !containsFold(e.opts.Tables, t.name) {
+		t.skip = true
+		return t, nil
+	}
+	schema, err := row.Schema()
+	if err != nil {
+		return nil, err
+	}
+	t.width = len(schema.Columns)
+	for i, c := range schema.Columns {
+		t.index[strings.ToLower(c.Name)] = i
+		if len(e.opts.Columns) == 0 || containsFold(e.opts.Columns, c.Name) || containsFold(e.opts.Columns, t.name+"."+c.Name) {
+			t.columns = append(t.columns, Column{Name: c.Name, Kind: kindOf(c.Type), Index: i})
+		}
+	}
+	if len(t.columns) == 0 {
+		fmt.Printf("%s has none of the selected columns, skipping it\n", t.name)
+		t.skip = true
+		return t, nil
+	}
+	for _, f := range e.opts.Filters {
+		if !f.appliesTo(t.name) {
+			continue
+		}
+		index, ok := t.index[strings.ToLower(f.Column)]
+		if !ok {
+			if len(f.Table) > 0 {
+				return nil, fmt.Errorf("%s has no column %s", t.name, f.Column)
+			}
+			continue
+		}
+		t.filters = append(t.filters, f)
+		t.filterIdx = append(t.filterIdx, index)
+	}
+	for _, column := range e.opts.Partition {
+		if index, ok := t.index[strings.ToLower(column)]; ok {
+			t.partition = append(t.partition, index)
+			t.partNames = append(t.partNames, strings.ToLower(column))
+		}
+	}
+	return t, nil
+}
+
+// values returns the row in table column order, reusing buf.
+func (t *table) values(row *sqldump.Row, buf []sqldump.Value) ([]sqldump.Value, error) {
+	if cap(buf) < t.width {
+		buf = make([]sqldump.Value, t.width)
+	}
+	buf = buf[:t.width]
+	if len(row.Columns) == 0 {
+		copy(buf, row.Values)
+		return buf, nil
+	}
+	// the reader shares the column list between the rows of an INSERT
+	if &row.Columns[0] != t.lastColumns {
+		t.mapping = make([]int, len(row.Columns))
+		for i, name := range row.Columns {
+			index, ok := t.index[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("%s has no column %s", t.name, name)
+			}
+			t.mapping[i] = index
+		}
+		t.lastColumns = &row.Columns[0]
+	}
+	for i := range buf {
+		buf[i] = sqldump.Value{}
+	}
+	for i, v := range row.Values {
+		buf[t.mapping[i]] = v
+	}
+	return buf, nil
+}
+
+func (t *table) match(row []sqldump.Value) bool {
+	for i, f := range t.filters {
+		if !f.Match(row[t.filterIdx[i]]) {
+			return false
+		}
+	}
+	return true
+}
+
+// writer returns the open file of the partition of row, opening a new part
+// file when needed.
+func (e *Exporter) writer(t *table, row []sqldump.Value) (rowWriter, error) {
+	dir := filepath.Join(e.opts.Dir, sanitize(t.name))
+	for i, index := range t.partition {
+		dir = filepath.Join(dir, t.partNames[i]+"="+sanitize(row[index].Text))
+	}
+	e.clock++
+	if f, ok := e.open[dir]; ok {
+		f.used = e.clock
+		return f.writer, nil
+	}
+	if len(e.open) >= MaxOpenFiles {
+		if err := e.evict(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	part := t.parts[dir]
+	t.parts[dir] = part + 1
+	path := filepath.Join(dir, fmt.Sprintf("part-%04d.%s", part, e.opts.Format))
+	var writer rowWriter
+	var err error
+	if e.opts.Format == "parquet" {
+		writer, err = newParquetWriter(path, t.name, t.columns)
+	} else {
+		writer, err = newJSONLWriter(path, t.columns)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.open[dir] = &openFile{writer: writer, used: e.clock}
+	return writer, nil
+}
+
+// evict closes the least recently written file.
+func (e *Exporter) evict() error {
+	oldest := ""
+	for key, f := range e.open {
+		if len(oldest) == 0 || f.used < e.open[oldest].used {
+			oldest = key
+		}
+	}
+	f := e.open[oldest]
+	delete(e.open, oldest)
+	return f.writer.Close()
+}
+
+// sanitize turns a value into a directory name.
+func sanitize(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return "unknown"
+	}
+	res := []rune{}
+	for _, r := range value {
+		if r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f && r != 0xfffd {
+			res = append(res, r)
+		} else {
+			res = append(res, '_')
+		}
+		if len(res) >= 64 {
+			break
+		}
+	}
+	if s := string(res); s != "." && s != ".." {
+		return s
+	}
+	return "unknown"
+}
+
+func kindOf(mysqlType string) Kind {
+	base := strings.ToLower(mysqlType)
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	switch {
+	case strings.HasSuffix(base, "int") || base == "integer" || base == "bit" || base == "year" || base == "bigint":
+		return KindInt
+	case base == "float" || base == "double" || base == "real" || base == "decimal" || base == "numeric":
+		return KindFloat
+	case base == "datetime" || base == "timestamp" || base == "date":
+		return KindTime
+	case strings.HasSuffix(base, "blob") || strings.HasSuffix(base, "binary"):
+		return KindBytes
+	}
+	return KindString
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
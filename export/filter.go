@@ -0,0 +1,103 @@
+package export
+
+import (
+	"fmt"
+	"libgen/sqldump"
+	"strconv"
+	"strings"
+)
+
+// Filter keeps the rows whose column compares to Value with Op. A filter only
+// applies to the tables having its column, or to Table when it is qualified.
+type Filter struct {
+	Table  string
+	Column string
+	Op     string
+	Value  string
+}
+
+// operators are tried longest first so >= is not read as >.
+var operators = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+// ParseFilter reads a filter like Language=English, Year>=2000 or
+// updated.Title~physics, ~ matches a substring case-insensitively.
+func ParseFilter(expr string) (Filter, error) {
+	at, op := -1, ""
+	for _, candidate := range operators {
+		if i := strings.Index(expr, candidate); i > 0 && (at < 0 || i < at || (i == at && len(candidate) > len(op))) {
+			at, op = i, candidate
+		}
+	}
+	if at < 0 {
+		return Filter{}, fmt.Errorf("invalid filter %q, expected column, operator (%s) and value", expr, strings.Join(operators, " "))
+	}
+	f := Filter{
+		Column: strings.TrimSpace(expr[:at]),
+		Op:     op,
+		Value:  strings.TrimSpace(expr[at+len(op):]),
+	}
+	if i := strings.Index(f.Column, "."); i >= 0 {
+		f.Table, f.Column = f.Column[:i], f.Column[i+1:]
+	}
+	if len(f.Column) == 0 {
+		return Filter{}, fmt.Errorf("invalid filter %q, the column is missing", expr)
+	}
+	return f, nil
+}
+
+func (f Filter) String() string {
+	column := f.Column
+	if len(f.Table) > 0 {
+		column = f.Table + "." + column
+	}
+	return column + f.Op + f.Value
+}
+
+// appliesTo reports whether the filter applies to table.
+func (f Filter) appliesTo(table string) bool {
+	return len(f.Table) == 0 || strings.EqualFold(f.Table, table)
+}
+
+// Match compares a value with the filter, numbers are compared as numbers
+// when both sides are numeric. NULL only matches != and an empty =.
+func (f Filter) Match(v sqldump.Value) bool {
+	text := v.Text
+	if v.IsNull() {
+		text = ""
+	}
+	if f.Op == "~" {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(f.Value))
+	}
+	cmp := 0
+	a, errA := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	b, errB := strconv.ParseFloat(f.Value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else if f.Op == "=" || f.Op == "!=" {
+		if !strings.EqualFold(text, f.Value) {
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(text, f.Value)
+	}
+	switch f.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
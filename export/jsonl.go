@@ -0,0 +1,84 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"libgen/sqldump"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// jsonlWriter writes one JSON object per row with the columns in table order.
+type jsonlWriter struct {
+	file    *os.File
+	w       *bufio.Writer
+	columns []Column
+	// keys holds the encoded "name": prefix of every column
+	keys [][]byte
+	line []byte
+}
+
+func newJSONLWriter(path string, columns []Column) (*jsonlWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &jsonlWriter{file: file, w: bufio.NewWriterSize(file, 1024*1024), columns: columns}
+	for i, c := range columns {
+		key, _ := json.Marshal(c.Name)
+		if i > 0 {
+			key = append([]byte{','}, key...)
+		}
+		w.keys = append(w.keys, append(key, ':'))
+	}
+	return w, nil
+}
+
+func (w *jsonlWriter) Write(values []sqldump.Value) error {
+	w.line = append(w.line[:0], '{')
+	for i, c := range w.columns {
+		w.line = append(w.line, w.keys[i]...)
+		w.line = appendJSON(w.line, values[c.Index], c.Kind)
+	}
+	w.line = append(w.line, '}', '\n')
+	_, err := w.w.Write(w.line)
+	return err
+}
+
+func (w *jsonlWriter) Close() error {
+	err := w.w.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// appendJSON encodes a value, numbers that do not parse and invalid dates are
+// kept as strings and zero dates are null.
+func appendJSON(b []byte, v sqldump.Value, kind Kind) []byte {
+	if v.IsNull() {
+		return append(b, "null"...)
+	}
+	text := strings.TrimSpace(v.Text)
+	switch kind {
+	case KindInt:
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return strconv.AppendInt(b, n, 10)
+		}
+	case KindFloat:
+		if f, err := strconv.ParseFloat(text, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return strconv.AppendFloat(b, f, 'g', -1, 64)
+		}
+	case KindTime:
+		if t, err := v.Time(); err == nil && t.IsZero() {
+			return append(b, "null"...)
+		}
+	case KindBytes:
+		encoded, _ := json.Marshal(v.Bytes())
+		return append(b, encoded...)
+	}
+	encoded, _ := json.Marshal(v.Text)
+	return append(b, encoded...)
+}
@@ -0,0 +1,156 @@
+package export
+
+import (
+	"bufio"
+	"libgen/sqldump"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// The row groups of a parquet file are buffered in memory and written once
+// they hold RowGroupRows rows or RowGroupBytes bytes of values.
+var (
+	RowGroupRows  = 100000
+	RowGroupBytes = 16 * 1024 * 1024
+)
+
+// parquetSchema is the root of the schema of a table, parquet.Group sorts its
+// fields by name while the columns keep the order of the table.
+type parquetSchema struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func (s *parquetSchema) Fields() []parquet.Field {
+	return s.fields
+}
+
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f *parquetField) Name() string {
+	return f.name
+}
+
+func (f *parquetField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// parquetWriter writes a table as a parquet file of optional columns, values
+// that do not convert to the column type are written as NULL.
+type parquetWriter struct {
+	file    *os.File
+	w       *bufio.Writer
+	writer  *parquet.Writer
+	columns []Column
+	row     parquet.Row
+	rows    int
+	size    int
+}
+
+func newParquetWriter(path string, table string, columns []Column) (*parquetWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	root := &parquetSchema{Group: parquet.Group{}}
+	for _, c := range columns {
+		var node parquet.Node
+		switch c.Kind {
+		case KindInt:
+			node = parquet.Int(64)
+		case KindFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		case KindTime:
+			node = parquet.Timestamp(parquet.Microsecond)
+		case KindBytes:
+			node = parquet.Leaf(parquet.ByteArrayType)
+		default:
+			node = parquet.String()
+		}
+		node = parquet.Optional(node)
+		root.Group[c.Name] = node
+		root.fields = append(root.fields, &parquetField{Node: node, name: c.Name})
+	}
+	w := &parquetWriter{file: file, w: bufio.NewWriterSize(file, 1024*1024), columns: columns}
+	w.writer = parquet.NewWriter(w.w,
+		parquet.NewSchema(table, root),
+		parquet.Compression(&parquet.Snappy),
+		parquet.CreatedBy("libgen", "", ""),
+	)
+	return w, nil
+}
+
+func (w *parquetWriter) Write(values []sqldump.Value) error {
+	w.row = w.row[:0]
+	for i, c := range w.columns {
+		v := parquetValue(values[c.Index], c.Kind)
+		w.size++
+		if v.IsNull() {
+			w.row = append(w.row, v.Level(0, 0, i))
+			continue
+		}
+		w.row = append(w.row, v.Level(0, 1, i))
+		if v.Kind() == parquet.ByteArray {
+			w.size += 4 + len(v.ByteArray())
+		} else {
+			w.size += 8
+		}
+	}
+	if _, err := w.writer.WriteRows([]parquet.Row{w.row}); err != nil {
+		return err
+	}
+	w.rows++
+	if w.rows < RowGroupRows && w.size < RowGroupBytes {
+		return nil
+	}
+	w.rows, w.size = 0, 0
+	return w.writer.Flush()
+}
+
+// parquetValue converts a value to the type of its column, NULL when it does
+// not convert.
+func parquetValue(v sqldump.Value, kind Kind) parquet.Value {
+	if v.IsNull() {
+		return parquet.NullValue()
+	}
+	text := strings.TrimSpace(v.Text)
+	switch kind {
+	case KindInt:
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return parquet.Int64Value(n)
+		}
+		return parquet.NullValue()
+	case KindFloat:
+		if f, err := strconv.ParseFloat(text, 64); err == nil && !math.IsNaN(f) {
+			return parquet.DoubleValue(f)
+		}
+		return parquet.NullValue()
+	case KindTime:
+		if t, err := v.Time(); err == nil && !t.IsZero() {
+			return parquet.Int64Value(t.UnixMicro())
+		}
+		return parquet.NullValue()
+	case KindBytes:
+		return parquet.ByteArrayValue([]byte(v.Text))
+	}
+	return parquet.ByteArrayValue([]byte(strings.ToValidUTF8(v.Text, "�")))
+}
+
+func (w *parquetWriter) Close() error {
+	err := w.writer.Close()
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
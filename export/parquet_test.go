@@ -0,0 +1,142 @@
+package export
+
+import (
+	"errors"
+	"io"
+	"libgen/sqldump"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func str(text string) sqldump.Value {
+	return sqldump.Value{Kind: sqldump.String, Text: text}
+}
+
+var null = sqldump.Value{Kind: sqldump.Null}
+
+// TestParquetRoundTrip reads a file written by parquetWriter back with
+// parquet-go and compares the schema, row count and every value.
+func TestParquetRoundTrip(t *testing.T) {
+	rowGroupRows := RowGroupRows
+	RowGroupRows = 2
+	defer func() { RowGroupRows = rowGroupRows }()
+
+	columns := []Column{
+		{Name: "ID", Kind: KindInt, Index: 0},
+		{Name: "Title", Kind: KindString, Index: 1},
+		{Name: "Price", Kind: KindFloat, Index: 2},
+		{Name: "TimeAdded", Kind: KindTime, Index: 3},
+		{Name: "Cover", Kind: KindBytes, Index: 4},
+	}
+	added := time.Date(2023, 9, 5, 12, 30, 0, 0, time.UTC)
+	rows := [][]sqldump.Value{
+		{str("1"), str("Dune"), str("9.5"), str("2023-09-05 12:30:00"), str("\x00\xff\x01")},
+		{str("2"), null, null, null, null},
+		{str("-3"), str(""), str("-0.25"), str("0000-00-00 00:00:00"), str("")},
+		{str("x"), str("caf\xe9"), str("nan"), str("bad"), str("b")},
+		{null, str("last"), str("1e3"), null, null},
+	}
+	path := filepath.Join(t.TempDir(), "part-0001.parquet")
+	w, err := newParquetWriter(path, "updated", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, _ := file.Stat()
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		t.Fatalf("parquet-go cannot open the file: %v", err)
+	}
+	if pf.NumRows() != int64(len(rows)) {
+		t.Fatalf("NumRows = %d, want %d", pf.NumRows(), len(rows))
+	}
+	if n := len(pf.RowGroups()); n != 3 {
+		t.Errorf("%d row groups, want 3", n)
+	}
+	kinds := []parquet.Kind{parquet.Int64, parquet.ByteArray, parquet.Double, parquet.Int64, parquet.ByteArray}
+	fields := pf.Schema().Fields()
+	if len(fields) != len(columns) {
+		t.Fatalf("%d fields, want %d", len(fields), len(columns))
+	}
+	for i, field := range fields {
+		if field.Name() != columns[i].Name || field.Type().Kind() != kinds[i] || !field.Optional() {
+			t.Errorf("field %d is %s %v optional=%v, want optional %s %v", i, field.Name(), field.Type().Kind(), field.Optional(), columns[i].Name, kinds[i])
+		}
+	}
+
+	got := make([]parquet.Row, 0, len(rows))
+	reader := parquet.NewReader(file)
+	buf := make([]parquet.Row, 2)
+	for {
+		n, err := reader.ReadRows(buf)
+		for _, row := range buf[:n] {
+			got = append(got, row.Clone())
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != len(rows) {
+		t.Fatalf("read %d rows, want %d", len(got), len(rows))
+	}
+
+	// nil stands for NULL
+	want := [][]any{
+		{int64(1), "Dune", 9.5, added.UnixMicro(), "\x00\xff\x01"},
+		{int64(2), nil, nil, nil, nil},
+		{int64(-3), "", -0.25, nil, ""},
+		{nil, "caf�", nil, nil, "b"},
+		{nil, "last", 1000.0, nil, nil},
+	}
+	for r, row := range got {
+		values := make([]parquet.Value, len(columns))
+		for _, v := range row {
+			values[v.Column()] = v
+		}
+		for c, v := range values {
+			expected := want[r][c]
+			if expected == nil {
+				if !v.IsNull() {
+					t.Errorf("row %d %s = %v, want NULL", r, columns[c].Name, v)
+				}
+				continue
+			}
+			if v.IsNull() {
+				t.Errorf("row %d %s is NULL, want %v", r, columns[c].Name, expected)
+				continue
+			}
+			var actual any
+			switch expected.(type) {
+			case int64:
+				actual = v.Int64()
+			case float64:
+				actual = v.Double()
+			case string:
+				actual = string(v.ByteArray())
+			}
+			if actual != expected {
+				t.Errorf("row %d %s = %#v, want %#v", r, columns[c].Name, actual, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"libgen/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nwaples/rardecode"
+)
+
+var ErrNoSpace = errors.New("not enough free space")
+
+// Reserve is kept free on the destination volume on top of the file being
+// extracted.
+var Reserve int64 = 64 * 1024 * 1024
+
+// Progress is called while a file is unpacked with the bytes unpacked so far
+// and the size of the file, total is -1 when the archive does not
+// record it.
+type Progress func(name string, written, total int64)
+
+// File is a file extracted from an archive.
+type File struct {
+	Name string
+	Path string
+	Size int64
+}
+
+type progressWriter struct {
+	name     string
+	written  int64
+	total    int64
+	progress Progress
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.progress != nil {
+		w.progress(w.name, w.written, w.total)
+	}
+	return len(p), nil
+}
+
+// contextReader fails once ctx is done, an interrupted archive is not read
+// any further.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Archive extracts every file of the rar archive into dest. The CRC stored
+// in the archive is checked at the end of every file, a file failing it is
+// removed and the extraction stops, as it does when ctx is done.
+func Archive(ctx context.Context, archive, dest string, progress Progress) ([]File, error) {
+	reader, err := rardecode.OpenReader(archive, "")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err = os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+	// the unpacked files are at least as large as the archive
+	if err = CheckSpace(dest, utils.GetFileSize(archive)); err != nil {
+		return nil, err
+	}
+
+	files := []File{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, err
+		}
+		target, err := targetPath(dest, header.Name)
+		if err != nil {
+			return files, err
+		}
+		if header.IsDir {
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+			continue
+		}
+		total := header.UnPackedSize
+		if header.UnKnownSize {
+			total = -1
+		} else if err = CheckSpace(dest, total); err != nil {
+			return files, fmt.Errorf("%s: %w", header.Name, err)
+		}
+		written, err := extractFile(&contextReader{ctx: ctx, r: reader}, target, header, &progressWriter{name: header.Name, total: total, progress: progress})
+		if ctx.Err() != nil {
+			return files, ctx.Err()
+		}
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{Name: header.Name, Path: target, Size: written})
+	}
+	return files, nil
+}
+
+func extractFile(reader io.Reader, target string, header *rardecode.FileHeader, progress io.Writer) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	tmp := target + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(io.MultiWriter(file, progress), reader)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return written, fmt.Errorf("%s is corrupt: %w", header.Name, err)
+	}
+	if !header.UnKnownSize && written != header.UnPackedSize {
+		os.Remove(tmp)
+		return written, fmt.Errorf("%s is corrupt: %w", header.Name, io.ErrUnexpectedEOF)
+	}
+	os.Remove(target)
+	if err = os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return written, err
+	}
+	if !header.ModificationTime.IsZero() {
+		os.Chtimes(target, time.Now(), header.ModificationTime)
+	}
+	return written, nil
+}
+
+// Stream hands every file of the archive accepted by match to fn while it is
+// decompressed, nothing is written to disk. Whatever fn leaves unread is
+// drained so the CRC of the file is still checked, a file failing it is
+// reported after fn returned. Reading stops with the error of ctx once it is
+// done.
+func Stream(ctx context.Context, archive string, match func(name string) bool, progress Progress, fn func(name string, r io.Reader) error) error {
+	reader, err := rardecode.OpenReader(archive, "")
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	found := false
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.IsDir || !match(header.Name) {
+			continue
+		}
+		found = true
+		total := header.UnPackedSize
+		if header.UnKnownSize {
+			total = -1
+		}
+		counter := &progressWriter{name: header.Name, total: total, progress: progress}
+		body := &contextReader{ctx: ctx, r: reader}
+		if err = fn(header.Name, io.TeeReader(body, counter)); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		if _, err = io.Copy(counter, body); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%s is corrupt: %w", header.Name, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s holds no matching file", filepath.Base(archive))
+	}
+	return nil
+}
+
+// targetPath joins an archive member name onto dest, names escaping dest are
+// rejected.
+func targetPath(dest, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(strings.ReplaceAll(name, "\\", "/")))
+	if filepath.IsAbs(clean) || len(filepath.VolumeName(clean)) > 0 || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s points outside of %s", name, dest)
+	}
+	return filepath.Join(dest, clean), nil
+}
+
+// CheckSpace fails with ErrNoSpace when dir cannot hold size more bytes and
+// the Reserve. Platforms that cannot report the free space pass.
+func CheckSpace(dir string, size int64) error {
+	free, err := utils.FreeSpace(dir)
+	if err != nil {
+		return nil
+	}
+	if free < size+Reserve {
+		return fmt.Errorf("%w: %s needed, %s free on %s", ErrNoSpace, utils.FormatBytes(size+Reserve), utils.FormatBytes(free), dir)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"libgen/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The events hooks fire on.
+const (
+	// Discovered fires when a dump newer than the last downloaded one is
+	// found on the mirror.
+	Discovered   = "discovered"
+	PartFailed   = "part_failed"
+	Merged       = "merged"
+	VerifyFailed = "verify_failed"
+	Complete     = "complete"
+)
+
+// Events lists every event in the order they happen.
+var Events = []string{Discovered, PartFailed, Merged, VerifyFailed, Complete}
+
+// DefaultTimeout is how long a hook runs when it sets no timeout.
+const DefaultTimeout = 30 * time.Second
+
+// Hook runs a shell command or posts the event as JSON to a webhook URL. The
+// command gets the event in LIBGEN_* environment variables.
+type Hook struct {
+	// Events are the events the hook fires on, every event when empty.
+	Events  []string `json:"events"`
+	Command string   `json:"command"`
+	URL     string   `json:"url"`
+	// Retries is how many times a failed hook is run again.
+	Retries int `json:"retries"`
+	// Timeout is how long in seconds an attempt may take.
+	Timeout int `json:"timeout"`
+}
+
+// Event describes what happened to a dump.
+type Event struct {
+	Event  string    `json:"event"`
+	Family string    `json:"family"`
+	Dump   string    `json:"dump"`
+	Path   string    `json:"path,omitempty"`
+	Url    string    `json:"url,omitempty"`
+	Size   int64     `json:"size,omitempty"`
+	Part   int       `json:"part,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+var (
+	// lck guards hooks, they are set again when the config file changes while
+	// hooks fire
+	lck     sync.Mutex
+	hooks   []Hook
+	running = sync.WaitGroup{}
+)
+
+// Set validates and registers the hooks.
+func Set(list []Hook) error {
+	for i, hook := range list {
+		if (len(hook.Command) == 0) == (len(hook.URL) == 0) {
+			return fmt.Errorf("hook %d needs either a command or a url", i+1)
+		}
+		for _, event := range hook.Events {
+			if !utils.InSlice(Events, event, false) {
+				return fmt.Errorf("hook %d: unknown event %q, expected one of %s", i+1, event, strings.Join(Events, ", "))
+			}
+		}
+	}
+	lck.Lock()
+	hooks = list
+	lck.Unlock()
+	return nil
+}
+
+func (h *Hook) matches(event string) bool {
+	return len(h.Events) == 0 || utils.InSlice(h.Events, event, false)
+}
+
+func (h *Hook) String() string {
+	if len(h.URL) > 0 {
+		return h.URL
+	}
+	return h.Command
+}
+
+// Fire runs the hooks registered for the event in the background, Wait
+// returns once they are done. Failed hooks are not retried once ctx is done.
+func Fire(ctx context.Context, e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	lck.Lock()
+	list := hooks
+	lck.Unlock()
+	for i := range list {
+		hook := &list[i]
+		if !hook.matches(e.Event) {
+			continue
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			if err := hook.run(ctx, e); err != nil {
+				fmt.Printf("Hook %s failed on %s: %v\n", hook, e.Event, err)
+			}
+		}()
+	}
+}
+
+// Wait waits for the hooks fired so far.
+func Wait() {
+	running.Wait()
+}
+
+// run runs the hook until it succeeds, its retries are used up or ctx is
+// done, waiting a little longer after every failure. The first attempt runs
+// even once ctx is done so that the events of a stopping download are still
+// delivered.
+func (h *Hook) run(ctx context.Context, e Event) error {
+	timeout := DefaultTimeout
+	if h.Timeout > 0 {
+		timeout = time.Second * time.Duration(h.Timeout)
+	}
+	var err error
+	for attempt := 0; attempt <= h.Retries; attempt++ {
+		if attempt > 0 {
+			if utils.Sleep(ctx, time.Second*time.Duration(attempt*2)) != nil {
+				return err
+			}
+		}
+		attemptCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		if len(h.URL) > 0 {
+			err = h.post(attemptCtx, e)
+		} else {
+			err = h.exec(attemptCtx, e)
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (h *Hook) exec(ctx context.Context, e Event) error {
+	cmd := utils.ShellCommand(ctx, h.Command)
+	cmd.Env = append(os.Environ(), e.Env()...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+	return err
+}
+
+func (h *Hook) post(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", utils.USERAGENT)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Env returns the event as LIBGEN_* environment variables.
+func (e Event) Env() []string {
+	env := []string{
+		"LIBGEN_EVENT=" + e.Event,
+		"LIBGEN_FAMILY=" + e.Family,
+		"LIBGEN_DUMP=" + e.Dump,
+		"LIBGEN_PATH=" + e.Path,
+		"LIBGEN_URL=" + e.Url,
+		"LIBGEN_SIZE=" + strconv.FormatInt(e.Size, 10),
+		"LIBGEN_TIME=" + e.Time.UTC().Format(time.RFC3339),
+	}
+	if e.Part > 0 {
+		env = append(env, "LIBGEN_PART="+strconv.Itoa(e.Part))
+	}
+	if len(e.Error) > 0 {
+		env = append(env, "LIBGEN_ERROR="+e.Error)
+	}
+	return env
+}
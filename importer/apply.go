@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"libgen/snapshot"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotTable records the snapshot an imported catalogue reflects.
+const snapshotTable = "_snapshot"
+
+// appliers maps the importers that can apply a changeset to their function.
+var appliers = map[string]func(target string, changes *snapshot.Changeset) ([]snapshot.TableChanges, error){
+	"sqlite":   applySQLite,
+	"postgres": applyPostgres,
+}
+
+// Apply updates the catalogue imported into target with a changeset in one
+// transaction. The catalogue has to reflect the base of the changeset, one
+// that reflects its snapshot already is left as is.
+func Apply(kind, target string, changes *snapshot.Changeset) ([]snapshot.TableChanges, error) {
+	apply, ok := appliers[strings.ToLower(kind)]
+	if !ok {
+		names := make([]string, 0, len(appliers))
+		for _, name := range Kinds() {
+			if _, ok := appliers[name]; ok {
+				names = append(names, name)
+			}
+		}
+		return nil, fmt.Errorf("%s cannot apply changesets, expected one of %s", kind, strings.Join(names, ", "))
+	}
+	return apply(target, changes)
+}
+
+// ErrApplied is returned when the catalogue already reflects the snapshot of
+// a changeset.
+var ErrApplied = errors.New("the changeset is already applied")
+
+// checkSnapshot compares the snapshot a catalogue reflects with the base of
+// the changeset. Catalogues imported before snapshots were recorded are
+// assumed to reflect the base.
+func checkSnapshot(current string, changes *snapshot.Changeset) error {
+	switch current {
+	case changes.Snapshot:
+		return fmt.Errorf("%w, the catalogue reflects %s", ErrApplied, current)
+	case changes.Base:
+		return nil
+	case "":
+		fmt.Printf("The catalogue does not record its snapshot, assuming it reflects %s\n", changes.Base)
+		return nil
+	}
+	return fmt.Errorf("the catalogue reflects %s but the changeset applies to %s", current, changes.Base)
+}
+
+// snapshotOf returns the dump a script passed to Import belongs to, e.g.
+// libgen_2023-09-05 for libgen_2023-09-05.rar/libgen.sql.
+func snapshotOf(name string) string {
+	dump := strings.SplitN(filepath.ToSlash(name), "/", 2)[0]
+	return strings.TrimSuffix(dump, filepath.Ext(dump))
+}
+
+// changeCounter counts the changes applied to every table.
+type changeCounter struct {
+	tables map[string]*snapshot.TableChanges
+	order  []string
+}
+
+func (c *changeCounter) add(change *snapshot.Change) {
+	if c.tables == nil {
+		c.tables = map[string]*snapshot.TableChanges{}
+	}
+	key := strings.ToLower(change.Table.Name)
+	t, ok := c.tables[key]
+	if !ok {
+		t = &snapshot.TableChanges{Table: change.Table.Name}
+		c.tables[key] = t
+		c.order = append(c.order, key)
+	}
+	switch change.Op {
+	case snapshot.Added:
+		t.Added++
+	case snapshot.Changed:
+		t.Changed++
+	case snapshot.Removed:
+		t.Removed++
+	}
+}
+
+func (c *changeCounter) summary() []snapshot.TableChanges {
+	res := make([]snapshot.TableChanges, 0, len(c.order))
+	for _, key := range c.order {
+		res = append(res, *c.tables[key])
+	}
+	return res
+}
@@ -0,0 +1,72 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Importer loads the SQL script of a dump into a database as it is read.
+type Importer interface {
+	// Import reads the script of name until EOF, name is unique per dump.
+	Import(name string, r io.Reader) error
+	// Close finishes the import, err is the error it failed with if any.
+	Close(err error) error
+}
+
+// kinds maps an importer name to its constructor, target is the database to
+// load into, its meaning depends on the importer.
+var kinds = map[string]func(target string) (Importer, error){
+	"mysql":    NewMySQL,
+	"sqlite":   NewSQLite,
+	"postgres": NewPostgres,
+}
+
+// TableSummary counts the rows an import loaded into a table, Skipped rows
+// were already loaded by an earlier, interrupted run.
+type TableSummary struct {
+	Table   string
+	Rows    int64
+	Skipped int64
+}
+
+// Summarizer is implemented by importers that count their rows per table.
+type Summarizer interface {
+	Summary() []TableSummary
+}
+
+// Kinds returns the names of the available importers.
+func Kinds() []string {
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the importer called kind loading into target.
+func New(kind, target string) (Importer, error) {
+	create, ok := kinds[strings.ToLower(kind)]
+	if !ok {
+		return nil, fmt.Errorf("unknown importer %q, expected one of %s", kind, strings.Join(Kinds(), ", "))
+	}
+	return create(target)
+}
+
+// DefaultTarget returns the target used when none is given, file based
+// databases are created next to the dump.
+func DefaultTarget(kind, dumpFile string) string {
+	switch strings.ToLower(kind) {
+	case "sqlite":
+		return strings.TrimSuffix(dumpFile, filepath.Ext(dumpFile)) + ".sqlite"
+	}
+	return ""
+}
+
+// IsScript reports whether an archive member is a SQL script.
+func IsScript(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".sql")
+}
@@ -0,0 +1,55 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// MySQLClient is the mysql command line client the scripts are piped to.
+var MySQLClient = "mysql"
+
+// MySQLArgs are passed to the client before the database name, the
+// credentials are otherwise read from its option files or MYSQL_* variables.
+var MySQLArgs = []string{}
+
+// MySQL pipes the scripts into the mysql client, the client executes them
+// statement by statement so nothing is buffered here.
+type MySQL struct {
+	database string
+}
+
+func NewMySQL(target string) (Importer, error) {
+	if len(target) == 0 {
+		return nil, errors.New("mysql expects a database name")
+	}
+	if _, err := exec.LookPath(MySQLClient); err != nil {
+		return nil, err
+	}
+	return &MySQL{database: target}, nil
+}
+
+func (m *MySQL) Import(name string, r io.Reader) error {
+	args := append(append([]string{}, MySQLArgs...), m.database)
+	cmd := exec.Command(MySQLClient, args...)
+	stderr := bytes.Buffer{}
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("%w (%s)", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// Close has nothing to undo, the client commits as it goes.
+func (m *MySQL) Close(err error) error {
+	return nil
+}
@@ -0,0 +1,650 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"libgen/snapshot"
+	"libgen/sqldump"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// PostgresSchema is the schema the catalogue is published in, it is loaded
+// into PostgresSchema_staging first and swapped in once complete.
+var PostgresSchema = "libgen"
+
+// Workers is the number of tables indexed at once after loading.
+var Workers = 4
+
+// copyBatch is the number of rows handed to a COPY worker at once.
+const copyBatch = 1000
+
+type pgTable struct {
+	name       string
+	columns    []string
+	types      []string
+	mysqlTypes []string
+	index      map[string]int
+	primaryKey []string
+	// mapping maps the values of the last column list to columns, lastColumns
+	// is the first name of that list, the reader reuses it for a statement
+	mapping     []int
+	lastColumns *string
+
+	rows  chan [][]any
+	batch [][]any
+	done  chan error
+	count int64
+}
+
+// Postgres loads the dump into a staging schema with COPY, every table is
+// streamed on its own connection, and swaps it with the published schema in
+// one transaction so readers never see a partial catalogue.
+type Postgres struct {
+	target  string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	conn    *pgx.Conn
+	staging string
+	// snapshot is the dump the catalogue reflects once published
+	snapshot string
+	tables   map[string]*pgTable
+	order    []string
+
+	lck sync.Mutex
+	err error
+}
+
+// NewPostgres connects to target, a connection string or url, the PG*
+// environment variables fill in whatever it leaves out.
+func NewPostgres(target string) (Importer, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := pgx.Connect(ctx, target)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	p := Postgres{
+		target:  target,
+		ctx:     ctx,
+		cancel:  cancel,
+		conn:    conn,
+		staging: PostgresSchema + "_staging",
+		tables:  map[string]*pgTable{},
+	}
+	staging := pgx.Identifier{p.staging}.Sanitize()
+	for _, query := range []string{
+		"DROP SCHEMA IF EXISTS " + staging + " CASCADE",
+		"CREATE SCHEMA " + staging,
+	} {
+		if _, err = conn.Exec(ctx, query); err != nil {
+			conn.Close(context.Background())
+			cancel()
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+func (p *Postgres) fail(err error) {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	if p.err == nil {
+		p.err = err
+		p.cancel()
+	}
+}
+
+func (p *Postgres) failure() error {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	return p.err
+}
+
+func (p *Postgres) Import(name string, r io.Reader) error {
+	p.snapshot = snapshotOf(name)
+	reader := sqldump.NewReader(r)
+	err := func() error {
+		for {
+			row, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			t, err := p.table(row)
+			if err != nil {
+				return err
+			}
+			values, err := t.convert(row)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", reader.Line(), err)
+			}
+			t.batch = append(t.batch, values)
+			if len(t.batch) >= copyBatch {
+				if err = p.send(t); err != nil {
+					return err
+				}
+			}
+		}
+	}()
+	// the workers of this script finish with their last batch
+	for _, key := range p.order {
+		t := p.tables[key]
+		if t.rows == nil {
+			continue
+		}
+		if err == nil && len(t.batch) > 0 {
+			err = p.send(t)
+		}
+		close(t.rows)
+		if copyErr := <-t.done; err == nil {
+			err = copyErr
+		}
+		t.rows = nil
+	}
+	if err == nil {
+		err = p.failure()
+	}
+	return err
+}
+
+func (p *Postgres) send(t *pgTable) error {
+	select {
+	case t.rows <- t.batch:
+		t.batch = make([][]any, 0, copyBatch)
+		return nil
+	case <-p.ctx.Done():
+		if err := p.failure(); err != nil {
+			return err
+		}
+		return p.ctx.Err()
+	}
+}
+
+// table creates the staging table on its first row and starts its COPY.
+func (p *Postgres) table(row *sqldump.Row) (*pgTable, error) {
+	key := strings.ToLower(row.Table.Name)
+	t, ok := p.tables[key]
+	if !ok {
+		schema, err := row.Schema()
+		if err != nil {
+			return nil, err
+		}
+		t = &pgTable{
+			name:  strings.ToLower(schema.Name),
+			index: map[string]int{},
+		}
+		defs := make([]string, 0, len(schema.Columns))
+		for i, c := range schema.Columns {
+			name := strings.ToLower(c.Name)
+			t.columns = append(t.columns, name)
+			t.types = append(t.types, postgresType(c.Type))
+			t.mysqlTypes = append(t.mysqlTypes, strings.ToLower(c.Type))
+			t.index[name] = i
+			defs = append(defs, pgx.Identifier{name}.Sanitize()+" "+t.types[i])
+		}
+		for _, k := range schema.PrimaryKey {
+			t.primaryKey = append(t.primaryKey, strings.ToLower(k))
+		}
+		query := "CREATE TABLE " + pgx.Identifier{p.staging, t.name}.Sanitize() + " (" + strings.Join(defs, ", ") + ")"
+		if _, err = p.conn.Exec(p.ctx, query); err != nil {
+			return nil, err
+		}
+		p.tables[key] = t
+		p.order = append(p.order, key)
+	}
+	if t.rows == nil {
+		t.rows = make(chan [][]any, 4)
+		t.done = make(chan error, 1)
+		t.batch = make([][]any, 0, copyBatch)
+		go p.copy(t, t.rows, t.done)
+	}
+	return t, nil
+}
+
+// copy streams the rows sent to the table into COPY on its own connection.
+func (p *Postgres) copy(t *pgTable, rows chan [][]any, done chan error) {
+	source := copySource{rows: rows}
+	conn, err := pgx.Connect(p.ctx, p.target)
+	if err == nil {
+		var n int64
+		n, err = conn.CopyFrom(p.ctx, pgx.Identifier{p.staging, t.name}, t.columns, &source)
+		t.count += n
+		conn.Close(context.Background())
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", t.name, err)
+		p.fail(err)
+	}
+	// let the sender close the channel without blocking
+	for range rows {
+	}
+	done <- err
+}
+
+type copySource struct {
+	rows  chan [][]any
+	batch [][]any
+	row   []any
+}
+
+func (s *copySource) Next() bool {
+	for len(s.batch) == 0 {
+		batch, ok := <-s.rows
+		if !ok {
+			return false
+		}
+		s.batch = batch
+	}
+	s.row, s.batch = s.batch[0], s.batch[1:]
+	return true
+}
+func (s *copySource) Values() ([]any, error) {
+	return s.row, nil
+}
+func (s *copySource) Err() error {
+	return nil
+}
+
+// convert returns the values of row in table column order.
+func (t *pgTable) convert(row *sqldump.Row) ([]any, error) {
+	if len(row.Columns) > 0 && &row.Columns[0] != t.lastColumns {
+		t.mapping = make([]int, len(row.Columns))
+		for i, name := range row.Columns {
+			index, ok := t.index[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("%s has no column %s", t.name, name)
+			}
+			t.mapping[i] = index
+		}
+		t.lastColumns = &row.Columns[0]
+	}
+	values := make([]any, len(t.columns))
+	for i, v := range row.Values {
+		index := i
+		if len(row.Columns) > 0 {
+			index = t.mapping[i]
+		}
+		if index >= len(values) {
+			return nil, fmt.Errorf("%s has %d columns but a row has %d values", t.name, len(values), len(row.Values))
+		}
+		value, err := postgresValue(v, t.types[index])
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.name, t.columns[index], err)
+		}
+		values[index] = value
+	}
+	return values, nil
+}
+
+// Close indexes the staging tables and swaps them in, a failed import only
+// drops the staging schema.
+func (p *Postgres) Close(err error) error {
+	defer p.cancel()
+	defer p.conn.Close(context.Background())
+	staging := pgx.Identifier{p.staging}.Sanitize()
+	if err != nil {
+		p.conn.Exec(context.Background(), "DROP SCHEMA IF EXISTS "+staging+" CASCADE")
+		return nil
+	}
+	if err = p.index(); err != nil {
+		p.conn.Exec(context.Background(), "DROP SCHEMA IF EXISTS "+staging+" CASCADE")
+		return err
+	}
+
+	if err = recordPostgresSnapshot(p.ctx, p.conn, p.staging, p.snapshot); err != nil {
+		p.conn.Exec(context.Background(), "DROP SCHEMA IF EXISTS "+staging+" CASCADE")
+		return err
+	}
+
+	fmt.Printf("Publishing %s\n", PostgresSchema)
+	schema := pgx.Identifier{PostgresSchema}.Sanitize()
+	old := pgx.Identifier{PostgresSchema + "_old"}.Sanitize()
+	tx, err := p.conn.Begin(p.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+	exists := false
+	if err = tx.QueryRow(p.ctx, "SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", PostgresSchema).Scan(&exists); err != nil {
+		return err
+	}
+	queries := []string{"DROP SCHEMA IF EXISTS " + old + " CASCADE"}
+	if exists {
+		queries = append(queries, "ALTER SCHEMA "+schema+" RENAME TO "+old)
+	}
+	queries = append(queries, "ALTER SCHEMA "+staging+" RENAME TO "+schema, "DROP SCHEMA IF EXISTS "+old+" CASCADE")
+	for _, query := range queries {
+		if _, err = tx.Exec(p.ctx, query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(p.ctx)
+}
+
+// index adds the primary keys and indexes of the staging tables, Workers
+// tables at a time.
+func (p *Postgres) index() error {
+	sem := make(chan struct{}, Workers)
+	wg := sync.WaitGroup{}
+	for _, key := range p.order {
+		t := p.tables[key]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := p.indexTable(t); err != nil {
+				p.fail(fmt.Errorf("%s: %w", t.name, err))
+			}
+		}()
+	}
+	wg.Wait()
+	return p.failure()
+}
+
+func (p *Postgres) indexTable(t *pgTable) error {
+	conn, err := pgx.Connect(p.ctx, p.target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	table := pgx.Identifier{p.staging, t.name}.Sanitize()
+	queries := []string{}
+	if len(t.primaryKey) > 0 {
+		keys := make([]string, 0, len(t.primaryKey))
+		for _, k := range t.primaryKey {
+			keys = append(keys, pgx.Identifier{k}.Sanitize())
+		}
+		queries = append(queries, "ALTER TABLE "+table+" ADD PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	for i, column := range t.columns {
+		if !indexed(column) {
+			continue
+		}
+		// btree entries are limited to a third of a page, long text columns
+		// get a hash index for equality lookups
+		method := "btree"
+		if t.types[i] == "text" && !shortText(t.mysqlTypes[i]) {
+			method = "hash"
+		}
+		index := pgx.Identifier{"idx_" + t.name + "_" + column}.Sanitize()
+		queries = append(queries, "CREATE INDEX "+index+" ON "+table+" USING "+method+" ("+pgx.Identifier{column}.Sanitize()+")")
+	}
+	queries = append(queries, "ANALYZE "+table)
+	for _, query := range queries {
+		fmt.Printf("%s: %s\n", t.name, query)
+		if _, err = conn.Exec(p.ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pgExecer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func recordPostgresSnapshot(ctx context.Context, conn pgExecer, schema, name string) error {
+	table := pgx.Identifier{schema, snapshotTable}.Sanitize()
+	for _, query := range []string{
+		"CREATE TABLE IF NOT EXISTS " + table + " (snapshot text NOT NULL, updated timestamptz NOT NULL DEFAULT now())",
+		"DELETE FROM " + table,
+	} {
+		if _, err := conn.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	_, err := conn.Exec(ctx, "INSERT INTO "+table+" (snapshot) VALUES ($1)", name)
+	return err
+}
+
+// applyBatch is the number of statements sent to the server at once when a
+// changeset is applied.
+const applyBatch = 500
+
+// pgChanges holds the statements applying the changes of a table.
+type pgChanges struct {
+	types    []string
+	keyTypes []string
+	remove   string
+	insert   string
+}
+
+func applyPostgres(target string, changes *snapshot.Changeset) ([]snapshot.TableChanges, error) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+	current := ""
+	conn.QueryRow(ctx, "SELECT snapshot FROM "+pgx.Identifier{PostgresSchema, snapshotTable}.Sanitize()).Scan(&current)
+	if err = checkSnapshot(current, changes); err != nil {
+		return nil, err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	tables := map[*snapshot.TableDef]*pgChanges{}
+	counter := changeCounter{}
+	batch := &pgx.Batch{}
+	send := func() error {
+		err := tx.SendBatch(ctx, batch).Close()
+		batch = &pgx.Batch{}
+		return err
+	}
+	for {
+		change, err := changes.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, ok := tables[change.Table]
+		if !ok {
+			// the queued statements may need the tables created here
+			if err = send(); err != nil {
+				return nil, err
+			}
+			if t, err = pgTableChanges(ctx, tx, change.Table); err != nil {
+				return nil, fmt.Errorf("%s: %w", change.Table.Name, err)
+			}
+			tables[change.Table] = t
+		}
+		key := make([]any, len(change.Table.Key))
+		for i := range key {
+			v := change.Values[i]
+			if change.Op != snapshot.Removed {
+				v = change.Values[change.Table.KeyIndex[i]]
+			}
+			if key[i], err = postgresValue(v.SQL(), t.keyTypes[i]); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", change.Table.Name, change.Table.Key[i], err)
+			}
+		}
+		batch.Queue(t.remove, key...)
+		if change.Op != snapshot.Removed {
+			values := make([]any, len(change.Values))
+			for i, v := range change.Values {
+				if values[i], err = postgresValue(v.SQL(), t.types[i]); err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", change.Table.Name, change.Table.Columns[i].Name, err)
+				}
+			}
+			batch.Queue(t.insert, values...)
+		}
+		counter.add(change)
+		if batch.Len() >= applyBatch {
+			if err = send(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = send(); err != nil {
+		return nil, err
+	}
+	if err = recordPostgresSnapshot(ctx, tx, PostgresSchema, changes.Snapshot); err != nil {
+		return nil, err
+	}
+	return counter.summary(), tx.Commit(ctx)
+}
+
+// pgTableChanges builds the statements of a table from the column types of
+// the published table, a table that is not published yet is created.
+func pgTableChanges(ctx context.Context, tx pgx.Tx, def *snapshot.TableDef) (*pgChanges, error) {
+	name := strings.ToLower(def.Name)
+	table := pgx.Identifier{PostgresSchema, name}.Sanitize()
+	declared := map[string]string{}
+	rows, err := tx.Query(ctx, "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2", PostgresSchema, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		column, typ := "", ""
+		if err = rows.Scan(&column, &typ); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		declared[column] = strings.TrimSuffix(typ, " without time zone")
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(declared) == 0 {
+		if len(def.Columns) == 0 {
+			return nil, errors.New("no such table")
+		}
+		defs := make([]string, 0, len(def.Columns)+1)
+		for _, c := range def.Columns {
+			column := strings.ToLower(c.Name)
+			declared[column] = postgresType(c.Type)
+			defs = append(defs, pgx.Identifier{column}.Sanitize()+" "+declared[column])
+		}
+		keys := make([]string, 0, len(def.Key))
+		for _, k := range def.Key {
+			keys = append(keys, pgx.Identifier{strings.ToLower(k)}.Sanitize())
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+		if _, err = tx.Exec(ctx, "CREATE TABLE "+table+" ("+strings.Join(defs, ", ")+")"); err != nil {
+			return nil, err
+		}
+	}
+	t := &pgChanges{}
+	where := make([]string, 0, len(def.Key))
+	for i, k := range def.Key {
+		column := strings.ToLower(k)
+		typ, ok := declared[column]
+		if !ok {
+			return nil, fmt.Errorf("no key column %s", k)
+		}
+		t.keyTypes = append(t.keyTypes, typ)
+		where = append(where, pgx.Identifier{column}.Sanitize()+" = $"+strconv.Itoa(i+1))
+	}
+	t.remove = "DELETE FROM " + table + " WHERE " + strings.Join(where, " AND ")
+	columns := make([]string, 0, len(def.Columns))
+	params := make([]string, 0, len(def.Columns))
+	for i, c := range def.Columns {
+		column := strings.ToLower(c.Name)
+		typ, ok := declared[column]
+		if !ok {
+			return nil, fmt.Errorf("no column %s", c.Name)
+		}
+		t.types = append(t.types, typ)
+		columns = append(columns, pgx.Identifier{column}.Sanitize())
+		params = append(params, "$"+strconv.Itoa(i+1))
+	}
+	t.insert = "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(params, ", ") + ")"
+	return t, nil
+}
+
+func (p *Postgres) Summary() []TableSummary {
+	res := make([]TableSummary, 0, len(p.order))
+	for _, key := range p.order {
+		res = append(res, TableSummary{Table: p.tables[key].name, Rows: p.tables[key].count})
+	}
+	return res
+}
+
+// shortText reports whether a MySQL char type holds at most 255 characters.
+func shortText(mysqlType string) bool {
+	open, end := strings.Index(mysqlType, "("), strings.Index(mysqlType, ")")
+	if !strings.Contains(mysqlType, "char") || open < 0 || end < open {
+		return false
+	}
+	n, err := strconv.Atoi(mysqlType[open+1 : end])
+	return err == nil && n <= 255
+}
+
+// postgresType maps a MySQL column type to a PostgreSQL type.
+func postgresType(mysqlType string) string {
+	base := strings.ToLower(mysqlType)
+	args := ""
+	if i := strings.Index(base, "("); i >= 0 {
+		if j := strings.Index(base, ")"); j > i {
+			args = base[i : j+1]
+		}
+		base = base[:i]
+	}
+	base = strings.TrimSpace(base)
+	switch {
+	case strings.HasSuffix(base, "int") || base == "integer" || base == "bit" || base == "year":
+		return "bigint"
+	case base == "float" || base == "real":
+		return "real"
+	case base == "double":
+		return "double precision"
+	case base == "decimal" || base == "numeric":
+		return "numeric" + args
+	case base == "datetime" || base == "timestamp":
+		return "timestamp"
+	case base == "date":
+		return "date"
+	case strings.HasSuffix(base, "blob") || strings.HasSuffix(base, "binary"):
+		return "bytea"
+	}
+	return "text"
+}
+
+func postgresValue(v sqldump.Value, pgType string) (any, error) {
+	if v.IsNull() {
+		return nil, nil
+	}
+	switch {
+	case pgType == "bigint":
+		return v.Int()
+	case pgType == "real" || pgType == "double precision":
+		return v.Float()
+	case strings.HasPrefix(pgType, "numeric"):
+		if len(strings.TrimSpace(v.Text)) == 0 {
+			return nil, nil
+		}
+		n := pgtype.Numeric{}
+		err := n.Scan(strings.TrimSpace(v.Text))
+		return n, err
+	case pgType == "timestamp" || pgType == "date":
+		t, err := v.Time()
+		if err != nil || t.IsZero() {
+			// MySQL zero dates have no PostgreSQL equivalent
+			return nil, err
+		}
+		return t, nil
+	case pgType == "bytea":
+		return v.Bytes(), nil
+	}
+	// text can neither hold NUL nor invalid UTF-8
+	return strings.ToValidUTF8(strings.ReplaceAll(v.Text, "\x00", ""), "\uFFFD"), nil
+}
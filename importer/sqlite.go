@@ -0,0 +1,510 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"libgen/snapshot"
+	"libgen/sqldump"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// BatchSize is the number of rows committed in one transaction, an
+// interrupted import resumes after the last committed batch.
+var BatchSize = 10000
+
+// IndexedColumns are indexed in every table that has them once the rows are
+// loaded.
+var IndexedColumns = []string{"MD5", "Identifier", "IdentifierWODash", "ISBN", "Title", "Author"}
+
+// progressTable records how far every table of every script got.
+const progressTable = "_import_progress"
+
+type sqliteTable struct {
+	name     string
+	columns  []string
+	affinity map[string]string
+	// done is the number of rows committed by earlier runs, seen counts the
+	// rows read in this run
+	done    int64
+	seen    int64
+	rows    int64
+	skipped int64
+}
+
+// SQLite converts the MySQL schema and rows of a dump into a SQLite file.
+type SQLite struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	stmts  map[string]*sql.Stmt
+	source string
+	// snapshot is the dump the catalogue reflects once imported
+	snapshot string
+	tables   map[string]*sqliteTable
+	order    []string
+	batch    int
+}
+
+func NewSQLite(target string) (Importer, error) {
+	if len(target) == 0 {
+		return nil, errors.New("sqlite expects a database file")
+	}
+	db, err := sql.Open("sqlite", target)
+	if err != nil {
+		return nil, err
+	}
+	// pragmas are set per connection
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+		"CREATE TABLE IF NOT EXISTS " + progressTable + " (source TEXT NOT NULL, tbl TEXT NOT NULL, rows INTEGER NOT NULL, complete INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (source, tbl))",
+	} {
+		if _, err = db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &SQLite{db: db, tables: map[string]*sqliteTable{}}, nil
+}
+
+func (s *SQLite) Import(name string, r io.Reader) error {
+	s.source = name
+	s.snapshot = snapshotOf(name)
+	complete := 0
+	s.db.QueryRow("SELECT complete FROM "+progressTable+" WHERE source = ? AND tbl = ''", name).Scan(&complete)
+	if complete == 1 {
+		fmt.Printf("%s is already imported\n", name)
+		return nil
+	}
+	for _, t := range s.tables {
+		t.done, t.seen = 0, 0
+	}
+	if err := s.begin(); err != nil {
+		return err
+	}
+	reader := sqldump.NewReader(r)
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		t, err := s.table(row)
+		if err != nil {
+			return err
+		}
+		t.seen++
+		if t.seen <= t.done {
+			t.skipped++
+			continue
+		}
+		if err = s.insert(t, row); err != nil {
+			return fmt.Errorf("line %d: %w", reader.Line(), err)
+		}
+		t.rows++
+		s.batch++
+		if s.batch >= BatchSize {
+			if err = s.commit(); err != nil {
+				return err
+			}
+			if err = s.begin(); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := s.tx.Exec("INSERT INTO "+progressTable+" (source, tbl, rows, complete) VALUES (?, '', 0, 1) ON CONFLICT (source, tbl) DO UPDATE SET complete = 1", name); err != nil {
+		return err
+	}
+	return s.commit()
+}
+
+// Close commits the last batch and indexes the tables, after a failure the
+// open batch is dropped and the next run resumes from the last commit.
+func (s *SQLite) Close(err error) error {
+	defer s.db.Close()
+	if err != nil {
+		if s.tx != nil {
+			s.tx.Rollback()
+		}
+		return nil
+	}
+	if s.tx != nil {
+		if err = s.commit(); err != nil {
+			return err
+		}
+	}
+	for _, name := range s.order {
+		t := s.tables[name]
+		for _, column := range t.columns {
+			if !indexed(column) {
+				continue
+			}
+			fmt.Printf("Indexing %s.%s\n", t.name, column)
+			index := quoteIdent("idx_" + t.name + "_" + column)
+			if _, err = s.db.Exec("CREATE INDEX IF NOT EXISTS " + index + " ON " + quoteIdent(t.name) + " (" + quoteIdent(column) + ")"); err != nil {
+				return err
+			}
+		}
+	}
+	if err = recordSQLiteSnapshot(s.db, s.snapshot); err != nil {
+		return err
+	}
+	_, err = s.db.Exec("PRAGMA optimize")
+	return err
+}
+
+type sqliteExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func recordSQLiteSnapshot(db sqliteExecer, name string) error {
+	for _, query := range []string{
+		"CREATE TABLE IF NOT EXISTS " + snapshotTable + " (snapshot TEXT NOT NULL, updated TEXT NOT NULL)",
+		"DELETE FROM " + snapshotTable,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec("INSERT INTO "+snapshotTable+" (snapshot, updated) VALUES (?, datetime('now'))", name)
+	return err
+}
+
+// sqliteChanges holds the statements applying the changes of a table.
+type sqliteChanges struct {
+	affinity    []string
+	keyAffinity []string
+	remove      *sql.Stmt
+	insert      *sql.Stmt
+}
+
+func applySQLite(target string, changes *snapshot.Changeset) ([]snapshot.TableChanges, error) {
+	if len(target) == 0 {
+		return nil, errors.New("sqlite expects a database file")
+	}
+	if _, err := os.Stat(target); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", target)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	current := ""
+	db.QueryRow("SELECT snapshot FROM " + snapshotTable).Scan(&current)
+	if err = checkSnapshot(current, changes); err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tables := map[*snapshot.TableDef]*sqliteChanges{}
+	counter := changeCounter{}
+	for {
+		change, err := changes.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, ok := tables[change.Table]
+		if !ok {
+			if t, err = sqliteTableChanges(tx, change.Table); err != nil {
+				return nil, fmt.Errorf("%s: %w", change.Table.Name, err)
+			}
+			tables[change.Table] = t
+		}
+		key := make([]any, len(change.Table.Key))
+		for i := range key {
+			v := change.Values[i]
+			if change.Op != snapshot.Removed {
+				v = change.Values[change.Table.KeyIndex[i]]
+			}
+			key[i] = sqliteValue(v.SQL(), t.keyAffinity[i])
+		}
+		if _, err = t.remove.Exec(key...); err != nil {
+			return nil, fmt.Errorf("%s: %w", change.Table.Name, err)
+		}
+		if change.Op != snapshot.Removed {
+			values := make([]any, len(change.Values))
+			for i, v := range change.Values {
+				values[i] = sqliteValue(v.SQL(), t.affinity[i])
+			}
+			if _, err = t.insert.Exec(values...); err != nil {
+				return nil, fmt.Errorf("%s: %w", change.Table.Name, err)
+			}
+		}
+		counter.add(change)
+	}
+	if err = recordSQLiteSnapshot(tx, changes.Snapshot); err != nil {
+		return nil, err
+	}
+	return counter.summary(), tx.Commit()
+}
+
+// sqliteTableChanges prepares the statements of a table, a table that is not
+// in the catalogue yet is created.
+func sqliteTableChanges(tx *sql.Tx, def *snapshot.TableDef) (*sqliteChanges, error) {
+	declared := map[string]string{}
+	rows, err := tx.Query("SELECT name, type FROM pragma_table_info(?)", def.Name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		name, typ := "", ""
+		if err = rows.Scan(&name, &typ); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		declared[strings.ToLower(name)] = sqliteAffinity(typ)
+	}
+	rows.Close()
+	if len(declared) == 0 {
+		if len(def.Columns) == 0 {
+			return nil, errors.New("no such table")
+		}
+		defs := make([]string, 0, len(def.Columns)+1)
+		for _, c := range def.Columns {
+			declared[strings.ToLower(c.Name)] = sqliteAffinity(c.Type)
+			defs = append(defs, quoteIdent(c.Name)+" "+sqliteAffinity(c.Type))
+		}
+		keys := make([]string, 0, len(def.Key))
+		for _, k := range def.Key {
+			keys = append(keys, quoteIdent(k))
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+		if _, err = tx.Exec("CREATE TABLE " + quoteIdent(def.Name) + " (" + strings.Join(defs, ", ") + ")"); err != nil {
+			return nil, err
+		}
+	}
+	t := &sqliteChanges{}
+	where := make([]string, 0, len(def.Key))
+	for _, k := range def.Key {
+		affinity, ok := declared[strings.ToLower(k)]
+		if !ok {
+			return nil, fmt.Errorf("no key column %s", k)
+		}
+		t.keyAffinity = append(t.keyAffinity, affinity)
+		where = append(where, quoteIdent(k)+" = ?")
+	}
+	if t.remove, err = tx.Prepare("DELETE FROM " + quoteIdent(def.Name) + " WHERE " + strings.Join(where, " AND ")); err != nil {
+		return nil, err
+	}
+	if len(def.Columns) == 0 {
+		return t, nil
+	}
+	quoted := make([]string, 0, len(def.Columns))
+	for _, c := range def.Columns {
+		affinity, ok := declared[strings.ToLower(c.Name)]
+		if !ok {
+			return nil, fmt.Errorf("no column %s", c.Name)
+		}
+		t.affinity = append(t.affinity, affinity)
+		quoted = append(quoted, quoteIdent(c.Name))
+	}
+	t.insert, err = tx.Prepare("INSERT INTO " + quoteIdent(def.Name) + " (" + strings.Join(quoted, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(quoted)-1) + ")")
+	return t, err
+}
+
+func (s *SQLite) Summary() []TableSummary {
+	res := make([]TableSummary, 0, len(s.order))
+	for _, name := range s.order {
+		t := s.tables[name]
+		res = append(res, TableSummary{Table: t.name, Rows: t.rows, Skipped: t.skipped})
+	}
+	return res
+}
+
+func indexed(column string) bool {
+	for _, c := range IndexedColumns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SQLite) begin() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	s.stmts = map[string]*sql.Stmt{}
+	return nil
+}
+
+// commit saves the position of every table with the rows of the batch.
+func (s *SQLite) commit() error {
+	for _, t := range s.tables {
+		if t.seen <= t.done {
+			continue
+		}
+		_, err := s.tx.Exec("INSERT INTO "+progressTable+" (source, tbl, rows) VALUES (?, ?, ?) ON CONFLICT (source, tbl) DO UPDATE SET rows = excluded.rows", s.source, t.name, t.seen)
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	s.batch = 0
+	return err
+}
+
+// table creates the SQLite table of a dump table on its first row and loads
+// the progress of an earlier run.
+func (s *SQLite) table(row *sqldump.Row) (*sqliteTable, error) {
+	key := strings.ToLower(row.Table.Name)
+	if t, ok := s.tables[key]; ok {
+		if t.seen == 0 {
+			s.tx.QueryRow("SELECT rows FROM "+progressTable+" WHERE source = ? AND tbl = ?", s.source, t.name).Scan(&t.done)
+		}
+		return t, nil
+	}
+	table, err := row.Schema()
+	if err != nil {
+		return nil, err
+	}
+	t := sqliteTable{
+		name:     table.Name,
+		affinity: map[string]string{},
+	}
+	defs := make([]string, 0, len(table.Columns)+1)
+	for _, c := range table.Columns {
+		affinity := sqliteAffinity(c.Type)
+		t.columns = append(t.columns, c.Name)
+		t.affinity[strings.ToLower(c.Name)] = affinity
+		defs = append(defs, quoteIdent(c.Name)+" "+affinity)
+	}
+	if len(table.PrimaryKey) > 0 {
+		keys := make([]string, 0, len(table.PrimaryKey))
+		for _, k := range table.PrimaryKey {
+			keys = append(keys, quoteIdent(k))
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	if _, err := s.tx.Exec("CREATE TABLE IF NOT EXISTS " + quoteIdent(t.name) + " (" + strings.Join(defs, ", ") + ")"); err != nil {
+		return nil, err
+	}
+	if err := s.clear(&t); err != nil {
+		return nil, err
+	}
+	s.tx.QueryRow("SELECT rows FROM "+progressTable+" WHERE source = ? AND tbl = ?", s.source, t.name).Scan(&t.done)
+	if t.done > 0 {
+		fmt.Printf("Resuming %s after %d rows\n", t.name, t.done)
+	}
+	s.tables[key] = &t
+	s.order = append(s.order, key)
+	return &t, nil
+}
+
+// clear empties a table before a dump loads it for the first time, the rows
+// an earlier dump left would otherwise stay behind, or be duplicated in tables
+// without a primary key.
+func (s *SQLite) clear(t *sqliteTable) error {
+	prefix := snapshotOf(s.source) + "/"
+	loaded := 0
+	err := s.tx.QueryRow("SELECT COUNT(*) FROM "+progressTable+" WHERE tbl = ? AND substr(source, 1, ?) = ?", t.name, len(prefix), prefix).Scan(&loaded)
+	if err != nil || loaded > 0 {
+		return err
+	}
+	res, err := s.tx.Exec("DELETE FROM " + quoteIdent(t.name))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		fmt.Printf("Replacing the %d rows of %s loaded from an earlier dump\n", n, t.name)
+	}
+	return nil
+}
+
+func (s *SQLite) insert(t *sqliteTable, row *sqldump.Row) error {
+	columns := row.Columns
+	if len(columns) == 0 {
+		columns = t.columns
+	}
+	key := t.name + "\x00" + strings.Join(columns, "\x00")
+	stmt, ok := s.stmts[key]
+	if !ok {
+		quoted := make([]string, 0, len(columns))
+		for _, c := range columns {
+			quoted = append(quoted, quoteIdent(c))
+		}
+		query := "INSERT INTO " + quoteIdent(t.name) + " (" + strings.Join(quoted, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(columns)-1) + ")"
+		var err error
+		if stmt, err = s.tx.Prepare(query); err != nil {
+			return err
+		}
+		s.stmts[key] = stmt
+	}
+	args := make([]any, len(row.Values))
+	for i, v := range row.Values {
+		args[i] = sqliteValue(v, t.affinity[strings.ToLower(columns[i])])
+	}
+	_, err := stmt.Exec(args...)
+	return err
+}
+
+// sqliteAffinity maps a MySQL column type to a SQLite type affinity.
+func sqliteAffinity(mysqlType string) string {
+	base := strings.ToLower(mysqlType)
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	switch {
+	case strings.HasSuffix(base, "int") || base == "integer" || base == "bit" || base == "year":
+		return "INTEGER"
+	case base == "float" || base == "double" || base == "real":
+		return "REAL"
+	case base == "decimal" || base == "numeric":
+		return "NUMERIC"
+	case strings.HasSuffix(base, "blob") || strings.HasSuffix(base, "binary"):
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+func sqliteValue(v sqldump.Value, affinity string) any {
+	switch v.Kind {
+	case sqldump.Null:
+		return nil
+	case sqldump.Binary:
+		if affinity != "TEXT" {
+			return v.Bytes()
+		}
+		return v.Text
+	}
+	switch affinity {
+	case "INTEGER":
+		if n, err := strconv.ParseInt(v.Text, 10, 64); err == nil {
+			return n
+		}
+	case "REAL":
+		if f, err := strconv.ParseFloat(v.Text, 64); err == nil {
+			return f
+		}
+	case "BLOB":
+		return v.Bytes()
+	}
+	// NUMERIC columns convert the text themselves without losing precision
+	return v.Text
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
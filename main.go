@@ -2,11 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"libgen/checksum"
+	"libgen/config"
 	"libgen/downloader"
+	"libgen/dumps"
+	"libgen/export"
+	"libgen/extract"
+	"libgen/hooks"
+	"libgen/importer"
+	"libgen/manifest"
+	"libgen/mirrors"
+	"libgen/ratelimit"
+	"libgen/retry"
+	"libgen/snapshot"
 	"libgen/utils"
+	"libgen/workers"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,46 +29,104 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-var LibGenFullRgx = `libgen_\d{4,}-\d{2,}-\d{2,}`
+// PartSize is the size of the ranged requests a dump is split into.
+var PartSize int64 = 1024 * 1024 * 20
+
+// DirectWrite makes new downloads write their parts straight into the dump
+// file instead of merging part files afterwards.
+var DirectWrite = false
+
+// Concurrency is the number of parts downloaded at once from every mirror
+// when a download starts, it adapts to the throughput and errors between
+// MinConcurrency and MaxConcurrency.
+var Concurrency = 5
+
+var (
+	MinConcurrency = 1
+	MaxConcurrency = 16
+)
+
+// StalledRounds is how many rounds over the pending parts may finish none of
+// them before a download gives up, it resumes on the next run.
+var StalledRounds = 3
+
+// AutoExtract unpacks a dump into its family directory once it is merged and
+// verified.
+var AutoExtract = false
+
+// Changesets indexes the records of every downloaded dump and writes what
+// changed since the previous one to a changeset.
+var Changesets = false
+
+// SnapshotDir is the directory of a family directory holding the record
+// indexes and changesets, it is kept when older dumps are removed.
+const SnapshotDir = "snapshots"
 
 type Part struct {
 	Start int64
 	Size  int64
 }
 
+// AssetDir overrides the asset directory next to the executable.
+var AssetDir = ""
+
 func GetAssetDir() string {
 	// return `H:\libgendb\asset`
 	dir := filepath.Join(utils.GetBaseDirectory(), "asset")
+	if len(AssetDir) > 0 {
+		dir = AssetDir
+	}
 	if !utils.Exists(dir) {
-		os.MkdirAll(dir, 0655)
+		os.MkdirAll(dir, 0755)
 	}
 	return dir
 }
-func GetLibgenDumps() []string {
+
+// GetFamilyDir returns the directory a dump family is downloaded to, every
+// family is tracked on its own.
+func GetFamilyDir(family *dumps.Family) string {
+	dir := filepath.Join(GetAssetDir(), family.Name)
+	if !utils.Exists(dir) {
+		os.MkdirAll(dir, 0755)
+	}
+	return dir
+}
+
+// ListDumps returns the dumps listed by the first mirror that answers and the
+// url of that mirror.
+func ListDumps(ctx context.Context) ([]string, string) {
 	dumps := make([]string, 0, 20)
-	var dumpUrl = "https://data.library.bz/dbdumps/"
-	resp, err := http.Get(dumpUrl)
-	trys := 0
-	for err != nil {
-		resp, err = http.Get(dumpUrl)
-		if err == nil {
-			break
+	for _, mirror := range mirrors.Available() {
+		dumpUrl := mirror.Url
+		var resp *http.Response
+		err := retry.Do(ctx, "list", dumpUrl, func(attempt int) error {
+			if err := utils.WaitForConnection(ctx); err != nil {
+				return err
+			}
+			var err error
+			resp, err = utils.GetResponse(ctx, dumpUrl, nil)
+			return err
+		})
+		if ctx.Err() != nil {
+			return dumps, ""
 		}
-		trys++
-		time.Sleep(time.Second)
-		utils.WaitForConnection()
-		if err != nil && trys > 5 {
-			return dumps
+		if err != nil {
+			fmt.Printf("Failed to list dumps on %s: %v\n", dumpUrl, err)
+			mirrors.MarkDown(dumpUrl)
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			mirrors.MarkFailed(dumpUrl)
+			continue
 		}
-	}
-	defer resp.Body.Close()
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err == nil {
 
 		doc.Find("tr").Each(func(i int, s *goquery.Selection) {
 			anchor := s.Find("td > a").First()
@@ -65,15 +137,17 @@ func GetLibgenDumps() []string {
 				}
 			}
 		})
+		mirrors.MarkHealthy(dumpUrl)
+		return dumps, dumpUrl
 	}
 
-	return dumps
+	return dumps, ""
 
 }
-func GetLastDowloadedDump() string {
+func GetLastDowloadedDump(family *dumps.Family) string {
 	downloaded := ""
 	rgx := regexp.MustCompile(`((-part-\d+.tmp)$)|((-part-\d+.rar)$)`)
-	dir := GetAssetDir()
+	dir := GetFamilyDir(family)
 	infos, err := os.ReadDir(dir)
 	if err == nil {
 		paths := make([]string, 0, 20)
@@ -90,9 +164,8 @@ func GetLastDowloadedDump() string {
 		sort.Slice(paths, func(i, j int) bool {
 			return paths[i] > paths[j]
 		})
-		rgx = regexp.MustCompile(LibGenFullRgx)
 		for _, dl := range paths {
-			if rgx.MatchString(dl) {
+			if family.Match(dl) {
 				downloaded = dl
 				break
 			}
@@ -101,17 +174,25 @@ func GetLastDowloadedDump() string {
 	return downloaded
 }
 
-var downloadedSignalFile = filepath.Join(utils.GetBaseDirectory(), "downloaded")
+// GetDownloadedSignalFile returns the file written once the latest dump of
+// the family is complete, libgen keeps the original name.
+func GetDownloadedSignalFile(family *dumps.Family) string {
+	name := "downloaded"
+	if family != dumps.Libgen {
+		name += "_" + family.Name
+	}
+	return filepath.Join(utils.GetBaseDirectory(), name)
+}
 
-func GetDumpToDownload() (string, int64) {
-	lastDownload := GetLastDowloadedDump()
+func GetDumpToDownload(ctx context.Context, family *dumps.Family) (string, int64) {
+	lastDownload := GetLastDowloadedDump(family)
 
 	link := ""
-	dumps := GetLibgenDumps()
+	available, mirror := ListDumps(ctx)
 	size := int64(0)
 
 	if len(lastDownload) > 0 {
-		for _, dump := range dumps {
+		for _, dump := range available {
 			if strings.Contains(dump, lastDownload) {
 				link = dump
 				if !strings.HasSuffix(link, ".rar") {
@@ -122,119 +203,329 @@ func GetDumpToDownload() (string, int64) {
 		}
 	}
 	if len(link) == 0 {
-		utils.DeleteAllFiles(GetAssetDir())
-		sort.Slice(dumps, func(i, j int) bool {
-			return dumps[i] > dumps[j]
-		})
-		rgx := regexp.MustCompile(LibGenFullRgx)
-		for _, dump := range dumps {
-			if rgx.MatchString(dump) {
-				link = dump
-				break
-			}
+		if Changesets && len(lastDownload) > 0 {
+			// the dump being replaced is the base of the next changeset
+			IndexDump(ctx, family, filepath.Join(GetFamilyDir(family), lastDownload))
+		}
+		if latest := family.Latest(available); len(latest) > 0 {
+			link = latest[0]
+			hooks.Fire(ctx, hooks.Event{Event: hooks.Discovered, Family: family.Name, Dump: family.Find(mirrors.Name(link)), Url: mirrors.Link(mirror, link)})
 		}
 	}
 	if len(link) > 0 {
 
-		link = "https://data.library.bz/dbdumps/" + link
-		headers, err := downloader.GetHeaders(link)
-		if err == nil {
-			size = headers.Size
+		link = mirrors.Link(mirror, link)
+		for _, candidate := range mirrors.Links(link) {
+			headers, err := downloader.GetHeaders(ctx, candidate)
+			if err == nil {
+				mirrors.MarkHealthy(candidate)
+				link = candidate
+				size = headers.Size
+				break
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			mirrors.MarkDown(candidate)
 		}
 	}
 	return link, size
 }
 
-func DownloadPart(destFile, link string, index int, start, size int64) error {
+// NewMirrorPool returns a pool of every available mirror that serves link
+// with the same size.
+func NewMirrorPool(ctx context.Context, link string, size int64) *mirrors.Pool {
+	candidates := mirrors.Links(link)
+	matching := make([]*downloader.Headers, len(candidates))
+	wg := sync.WaitGroup{}
+	for i, candidate := range candidates {
+		wg.Add(1)
+		go func(idx int, lnk string) {
+			defer wg.Done()
+			headers, err := downloader.GetHeaders(ctx, lnk)
+			if err == nil && headers.Size == size {
+				matching[idx] = headers
+			}
+		}(i, candidate)
+	}
+	wg.Wait()
+	links := make([]string, 0, len(candidates))
+	for i, candidate := range candidates {
+		if matching[i] != nil {
+			links = append(links, candidate)
+		}
+	}
+	if len(links) == 0 {
+		links = append(links, link)
+	}
+	pool := mirrors.NewPool(links)
+	for i, candidate := range candidates {
+		if matching[i] != nil {
+			pool.SetValidator(candidate, matching[i].Validator())
+		}
+	}
+	return pool
+}
+
+// GetPartFile returns the file part index of destFile is downloaded to.
+func GetPartFile(destFile string, index int) string {
+	return filepath.Join(filepath.Dir(destFile), utils.RemoveExt(filepath.Base(destFile))+fmt.Sprintf("-part-%d%s", index+1, filepath.Ext(destFile)))
+}
+
+// DownloadPartFromPool downloads a part from the mirror the pool picks, a
+// failed attempt is retried on a different mirror. It returns the checksums
+// of the part computed while it streamed in. The bytes of an interrupted
+// part are kept in its temporary file and the next attempt resumes there.
+func DownloadPartFromPool(ctx context.Context, pool *mirrors.Pool, destFile string, index int, start, size int64) (checksum.Sums, error) {
 
-	tempFile := filepath.Join(filepath.Dir(destFile), utils.RemoveExt(filepath.Base(destFile))+fmt.Sprintf("-part-%d.tmp", index+1))
-	targetFile := utils.RemoveExt(tempFile) + filepath.Ext(destFile)
-	defer os.Remove(tempFile)
+	targetFile := GetPartFile(destFile, index)
+	tempFile := utils.RemoveExt(targetFile) + ".tmp"
 	if utils.Exists(targetFile) {
 
 		if utils.GetFileSize(targetFile) == size {
-			return nil
+			return checksum.File(targetFile)
 		}
 		os.Remove(targetFile)
 	}
-	var err error = nil
-	for trys := 0; trys < 5; trys++ {
-		res, err := utils.GetResponse(link, &map[string]string{
-			"Range": fmt.Sprintf("bytes=%d-%d", start, (start+size)-1),
-		})
+	name := fmt.Sprintf("Part %d of %s", index+1, filepath.Base(destFile))
+	return retryPart(ctx, pool, name, size, func(link, validator string) (checksum.Sums, error) {
+		sums, err := fetchPart(ctx, tempFile, link, validator, start, size)
 		if err == nil {
-			if res.ContentLength != size {
-				return errors.New("partial content does not match size")
-			}
-			defer res.Body.Close()
+			err = utils.MoveOrCopyFile(tempFile, targetFile)
+		}
+		return sums, err
+	})
+}
 
-			file, err := os.OpenFile(tempFile, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0755)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			rem := size
-			ln := int64(0)
-			for rem > 0 {
-				ln, err = io.CopyN(file, res.Body, 1024*20)
-
-				if err == io.EOF {
-					err = nil
-					file.Close()
-					if utils.GetFileSize(tempFile) != size {
-						err = errors.New("file size does not match")
-					}
-					break
-				}
-				if err != nil {
-					file.Close()
-					break
-				}
-				rem -= ln
-			}
-			file.Close()
-			if err == nil {
-				return utils.MoveOrCopyFile(tempFile, targetFile)
+// DownloadPartAt downloads a part straight into the preallocated dump file at
+// the offset of the part, there is nothing to merge afterwards.
+func DownloadPartAt(ctx context.Context, pool *mirrors.Pool, file *os.File, start, size int64) (checksum.Sums, error) {
+	name := fmt.Sprintf("Bytes %d-%d of %s", start, start+size-1, filepath.Base(file.Name()))
+	return retryPart(ctx, pool, name, size, func(link, validator string) (checksum.Sums, error) {
+		hasher := checksum.NewHasher()
+		err := fetchRange(ctx, io.MultiWriter(io.NewOffsetWriter(file, start), hasher), link, validator, start, size)
+		if syncErr := file.Sync(); err == nil {
+			err = syncErr
+		}
+		return hasher.Sums(), err
+	})
+}
 
-			}
+// OpenDirectFile opens the dump file parts are written into by DownloadPartAt
+// and grows it to its final size, the file stays sparse until the parts land.
+func OpenDirectFile(destFile string, size int64) (*os.File, error) {
+	file, err := os.OpenFile(destFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if utils.GetFileSize(destFile) != size {
+		if err = file.Truncate(size); err != nil {
+			file.Close()
+			return nil, err
 		}
-		time.Sleep(time.Second)
-		utils.WaitForConnection()
 	}
-	return err
+	return file, nil
 }
-func GetPart(link string, start, size int64) []byte {
 
-	for trys := 0; trys < 5; trys++ {
-		res, err := utils.GetResponse(link, &map[string]string{
-			"Range": fmt.Sprintf("bytes=%d-%d", start, (start+size)-1),
-		})
-		if err == nil {
-			if res.ContentLength != size {
-				return nil
+// retryPart fetches a part with the retry policy, every attempt picks the
+// mirror expected to finish it the soonest among those that did not fail it
+// yet. A mirror answering that it does not have the part hands it to the
+// next one right away.
+func retryPart(ctx context.Context, pool *mirrors.Pool, name string, size int64, fetch func(link, validator string) (checksum.Sums, error)) (checksum.Sums, error) {
+	sums := checksum.Sums{}
+	tried := map[string]bool{}
+	links := len(pool.Links())
+	err := retry.Do(ctx, "part", name, func(attempt int) error {
+		if err := utils.WaitForConnection(ctx); err != nil {
+			return err
+		}
+		for {
+			link := pool.Pick(tried)
+			began := time.Now()
+			var err error
+			sums, err = fetch(link, pool.Validator(link))
+			if ctx.Err() != nil {
+				pool.Release(link)
+				return ctx.Err()
 			}
-			defer res.Body.Close()
-			bytesBuffer := make([]byte, 0, 1024)
-			writer := bytes.NewBuffer(bytesBuffer)
-
-			if err != nil {
+			pool.Report(link, size, time.Since(began), err)
+			if err == nil {
 				return nil
 			}
+			if err == downloader.ErrEntityChanged {
+				return retry.Permanent(err)
+			}
+			tried[link] = true
+			if !retry.IsPermanent(err) || len(tried) >= links {
+				return err
+			}
+		}
+	})
+	return sums, err
+}
 
-			ln, err := io.CopyN(writer, res.Body, size)
-
-			if err == nil || ln == size {
-				return writer.Bytes()
+// fetchPart downloads a part to tempFile after the bytes an interrupted
+// attempt left there, the file is synced before returning either way.
+func fetchPart(ctx context.Context, tempFile, link, validator string, start, size int64) (checksum.Sums, error) {
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return checksum.Sums{}, err
+	}
+	defer file.Close()
+	hasher := checksum.NewHasher()
+	have, err := io.Copy(hasher, io.LimitReader(file, size))
+	if err != nil {
+		return checksum.Sums{}, err
+	}
+	if have == size {
+		// the part was complete but not moved, fetch it again
+		hasher, have = checksum.NewHasher(), 0
+	}
+	if err = file.Truncate(have); err != nil {
+		return checksum.Sums{}, err
+	}
+	if _, err = file.Seek(have, io.SeekStart); err != nil {
+		return checksum.Sums{}, err
+	}
+	err = fetchRange(ctx, io.MultiWriter(file, hasher), link, validator, start+have, size-have)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if err != nil {
+		return checksum.Sums{}, err
+	}
+	file.Close()
+	if utils.GetFileSize(tempFile) != size {
+		os.Remove(tempFile)
+		return checksum.Sums{}, errors.New("file size does not match")
+	}
+	return hasher.Sums(), nil
+}
 
-			}
+// fetchRange copies size bytes at start of link to dst, the request is
+// aborted when ctx is done.
+func fetchRange(ctx context.Context, dst io.Writer, link, validator string, start, size int64) error {
+	reqH := downloader.RangeHeaders(start, (start+size)-1, validator)
+	res, err := utils.GetResponse(ctx, link, &reqH)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err = downloader.CheckRange(res, validator); err != nil {
+		return err
+	}
+	if res.ContentLength != size {
+		// the mirror serves another range or file, asking again does not help
+		return retry.Permanent(errors.New("partial content does not match size"))
+	}
 
+	body := ratelimit.Reader(ctx, res.Body)
+	rem := size
+	ln := int64(0)
+	for rem > 0 {
+		ln, err = io.CopyN(dst, body, 1024*20)
+		rem -= ln
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
 		}
-		time.Sleep(time.Second)
-		utils.WaitForConnection()
+	}
+	if rem != 0 {
+		return errors.New("partial content does not match size")
 	}
 	return nil
 }
 
+// OpenManifest loads the manifest of destFile. A manifest written for a
+// different remote file is discarded together with the downloaded parts, a
+// new manifest adopts parts left by a download that had none.
+func OpenManifest(ctx context.Context, destFile, link string, size int64) *manifest.Manifest {
+	etag, lastModified := "", ""
+	if headers, err := downloader.GetHeaders(ctx, link); err == nil {
+		etag, lastModified = headers.ETag, headers.LastModified
+	}
+	path := manifest.PathFor(destFile)
+	m, err := manifest.Load(path)
+	if err == nil && m.Changed(link, size, etag, lastModified) {
+		fmt.Printf("%s changed on the mirror, restarting the download\n", filepath.Base(destFile))
+		CleanDownloadedParts(destFile)
+		os.Remove(destFile)
+		os.Remove(path)
+		m = nil
+	}
+	if m == nil {
+		if DirectWrite {
+			// a file left by an earlier merge or direct download says
+			// nothing about which of its ranges are good
+			os.Remove(destFile)
+		}
+		m = manifest.New(path, link, size, PartSize)
+		m.ETag, m.LastModified = etag, lastModified
+		m.Direct = DirectWrite
+		for _, p := range m.Parts {
+			if !m.Direct && utils.GetFileSize(GetPartFile(destFile, p.Index)) == p.Size {
+				m.Parts[p.Index].State = manifest.PartDone
+			}
+		}
+		if err := m.Save(); err != nil {
+			fmt.Printf("Failed to write %s: %v\n", path, err)
+		}
+		return m
+	}
+	if m.Complete {
+		if utils.GetFileSize(destFile) == size {
+			return m
+		}
+		m.SetComplete(false)
+	}
+	directLost := m.Direct && utils.GetFileSize(destFile) != m.Size
+	for _, p := range m.Parts {
+		if p.State != manifest.PartDone {
+			continue
+		}
+		if directLost || (!m.Direct && utils.GetFileSize(GetPartFile(destFile, p.Index)) != p.Size) {
+			m.SetPart(p.Index, manifest.PartPending, checksum.Sums{})
+		}
+	}
+	return m
+}
+func GetPart(ctx context.Context, link string, start, size int64) []byte {
+
+	candidates := mirrors.Links(link)
+	var part []byte
+	retry.Do(ctx, "range", fmt.Sprintf("Bytes %d-%d of %s", start, start+size-1, mirrors.Name(link)), func(attempt int) error {
+		if err := utils.WaitForConnection(ctx); err != nil {
+			return err
+		}
+		link := candidates[(attempt-1)%len(candidates)]
+		res, err := utils.GetResponse(ctx, link, &map[string]string{
+			"Range": fmt.Sprintf("bytes=%d-%d", start, (start+size)-1),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				mirrors.MarkFailed(link)
+			}
+			return err
+		}
+		defer res.Body.Close()
+		if res.ContentLength != size {
+			return retry.Permanent(errors.New("partial content does not match size"))
+		}
+		bytesBuffer := make([]byte, 0, 1024)
+		writer := bytes.NewBuffer(bytesBuffer)
+		if _, err = io.CopyN(writer, res.Body, size); err != nil {
+			mirrors.MarkFailed(link)
+			return err
+		}
+		mirrors.MarkHealthy(link)
+		part = writer.Bytes()
+		return nil
+	})
+	return part
+}
+
 var mapLck = sync.Mutex{}
 
 func DeletePartMapKey(parts map[int]Part, key int) {
@@ -242,11 +533,12 @@ func DeletePartMapKey(parts map[int]Part, key int) {
 	defer mapLck.Unlock()
 	delete(parts, key)
 }
-func CleanDownloadedParts() bool {
-	dlrgx := regexp.MustCompile(`(-part-\d+.rar)$`)
+func CleanDownloadedParts(filename string) bool {
+	dlrgx := regexp.MustCompile(`-part-\d+\.(rar|tmp)$`)
+	prefix := utils.RemoveExt(filepath.Base(filename))
 	res := true
-	for _, part := range utils.GetInfosFromDir(GetAssetDir()) {
-		if dlrgx.MatchString(part.FullPath) {
+	for _, part := range utils.GetInfosFromDir(filepath.Dir(filename)) {
+		if strings.HasPrefix(filepath.Base(part.FullPath), prefix) && dlrgx.MatchString(part.FullPath) {
 			err := os.Remove(part.FullPath)
 			res = res && err == nil
 			if !res {
@@ -256,36 +548,37 @@ func CleanDownloadedParts() bool {
 	}
 	return res
 }
-func VerifyPartsFromNetwork(link, filename string, totalSize, partSize int64) bool {
+func VerifyPartsFromNetwork(ctx context.Context, link, filename string, totalSize, partSize int64) bool {
 
 	splitParts := SplitFileParts(totalSize, int(partSize))
 	networkBufferMap := map[int][]byte{}
-	netWg := sync.WaitGroup{}
+	bufferLck := sync.Mutex{}
 
-	netDownloading := 0
+	work := workers.New(MinConcurrency, MaxConcurrency, Concurrency)
 	for key, _part := range splitParts {
-		netDownloading++
-		netWg.Add(1)
-		go func(part Part, idx int) {
-			defer func() {
-				netDownloading--
-				netWg.Done()
-
-			}()
+		part, idx := _part, key
+		err := work.Go(ctx, func() (int64, error) {
 			partBufferSize := 1024
 			if partBufferSize > int(partSize) {
 				partBufferSize = int(partSize)
 			}
-			buff := GetPart(link, part.Start, int64(partBufferSize))
+			buff := GetPart(ctx, link, part.Start, int64(partBufferSize))
+			bufferLck.Lock()
 			networkBufferMap[idx] = buff
-
-		}(_part, key)
-		for netDownloading > 5 {
-			time.Sleep(time.Second)
+			bufferLck.Unlock()
+			if buff == nil {
+				return 0, fmt.Errorf("failed to read part %d", idx+1)
+			}
+			return int64(len(buff)), nil
+		})
+		if err != nil {
+			break
 		}
-
 	}
-	netWg.Wait()
+	work.Wait()
+	if ctx.Err() != nil {
+		return false
+	}
 
 	dlrgx := regexp.MustCompile(`(-part-\d+.rar)$`)
 	digitRgx := regexp.MustCompile(`\D+`)
@@ -333,6 +626,34 @@ func VerifyPartsFromNetwork(link, filename string, totalSize, partSize int64) bo
 
 	return err == nil && equal
 }
+
+// VerifyFileFromNetwork compares the start of every part of a merged dump
+// with the mirror.
+func VerifyFileFromNetwork(ctx context.Context, link, filename string, partSize int64) bool {
+	file, err := os.OpenFile(filename, os.O_RDONLY, 0755)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	for _, part := range SplitFileParts(utils.GetFileSize(filename), int(partSize)) {
+		bufferSize := int64(1024)
+		if bufferSize > part.Size {
+			bufferSize = part.Size
+		}
+		netBuffer := GetPart(ctx, link, part.Start, bufferSize)
+		if netBuffer == nil {
+			return false
+		}
+		fileBuffer := make([]byte, bufferSize)
+		if _, err := file.ReadAt(fileBuffer, part.Start); err != nil {
+			return false
+		}
+		if !bytes.Equal(fileBuffer, netBuffer) {
+			return false
+		}
+	}
+	return true
+}
 func VerifyBytes(filename string) bool {
 	dlrgx := regexp.MustCompile(`(-part-\d+.rar)$`)
 	digitRgx := regexp.MustCompile(`\D+`)
@@ -344,6 +665,12 @@ func VerifyBytes(filename string) bool {
 		defer destFile.Close()
 		parts, err := GetSortedParts(filename)
 		if err == nil {
+			// every part but the last has the same size, the offsets are
+			// multiples of the first one
+			stride := int64(0)
+			if len(parts) > 0 {
+				stride = utils.GetFileSize(parts[0])
+			}
 			for _, part := range parts {
 
 				idxStr := dlrgx.FindString(part)
@@ -357,7 +684,7 @@ func VerifyBytes(filename string) bool {
 						return false
 					}
 					defer partFile.Close()
-					destPos := partSize * int64(key)
+					destPos := stride * int64(key)
 					if destPos > destSize {
 						return false
 					}
@@ -365,6 +692,9 @@ func VerifyBytes(filename string) bool {
 					if (destSize - destPos) < int64(bufferSize) {
 						bufferSize = int(destSize - destPos)
 					}
+					if partSize < int64(bufferSize) {
+						bufferSize = int(partSize)
+					}
 
 					destBuffer := make([]byte, bufferSize)
 					partBuffer := make([]byte, bufferSize)
@@ -420,11 +750,17 @@ func VerifyBytes(filename string) bool {
 
 	return err == nil
 }
-func MergeParts(filename string) error {
+
+// MergeParts merges the downloaded parts of filename, checking ctx between
+// parts.
+func MergeParts(ctx context.Context, filename string) error {
 	parts, err := GetSortedParts(filename)
 	if err != nil {
 		return err
 	}
+	if len(parts) == 0 {
+		return errors.New("no parts to merge")
+	}
 	size := int64(0)
 	for _, part := range parts {
 		size += utils.GetFileSize(part)
@@ -438,31 +774,49 @@ func MergeParts(filename string) error {
 		}
 		os.Remove(filename)
 	}
-	file, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0755)
+	file, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	// the parts are hashed again while merging so a part damaged on disk
+	// since it was downloaded is caught, the whole file is hashed on the way
+	m, _ := manifest.Load(manifest.PathFor(filename))
+	fileHasher := checksum.NewHasher()
+	writer := io.MultiWriter(file, fileHasher)
 	mergedBytes := int64(0)
 
 	total := len(parts)
 	counter := 0
 	if total > 0 {
 		fmt.Println("Merging files")
-		for _, filePart := range parts {
+		for idx, filePart := range parts {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			counter++
 			input, err := os.OpenFile(filePart, os.O_RDONLY, 0755)
 			if err != nil {
 				return err
 			}
 			defer input.Close()
-			ln, err := io.Copy(file, input)
+			partHasher := checksum.NewHasher()
+			ln, err := io.Copy(writer, io.TeeReader(input, partHasher))
 
 			if err != nil {
 				return err
 			}
 			input.Close()
+			if m != nil {
+				if p, ok := m.Part(idx); ok {
+					if _, err := p.Sums.Compare(partHasher.Sums()); err != nil {
+						os.Remove(filePart)
+						m.SetPart(idx, manifest.PartPending, checksum.Sums{})
+						return fmt.Errorf("%s is corrupt: %w", filepath.Base(filePart), err)
+					}
+				}
+			}
 			mergedBytes += ln
 			progress := (float64(counter) * 100) / float64(total)
 			fmt.Printf("Merged (%s/%s) :Progress %.2f%%\n", utils.FormatBytes(mergedBytes), utils.FormatBytes(size), progress)
@@ -475,12 +829,313 @@ func MergeParts(filename string) error {
 	if !VerifyBytes(filename) {
 		return errors.New("bytes do not match")
 	}
+	if m != nil {
+		m.SetSums(fileHasher.Sums())
+	}
 	return nil
 }
+
+// VerifyPartChecksums hashes every downloaded part of filename and compares
+// it with the checksums recorded in the manifest while it was downloaded.
+func VerifyPartChecksums(filename string, m *manifest.Manifest) bool {
+	if m == nil {
+		return true
+	}
+	for _, p := range m.Parts {
+		partFile := GetPartFile(filename, p.Index)
+		if m.Direct {
+			partFile = filename
+		}
+		if p.State != manifest.PartDone || !utils.Exists(partFile) {
+			continue
+		}
+		var sums checksum.Sums
+		var err error
+		if m.Direct {
+			sums, err = checksum.Range(filename, p.Start, p.Size)
+		} else {
+			sums, err = checksum.File(partFile)
+		}
+		if err != nil {
+			return false
+		}
+		if _, err := p.Sums.Compare(sums); err != nil {
+			fmt.Printf("part %d of %s is corrupt\n", p.Index+1, filepath.Base(filename))
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyChecksums compares the merged dump with its manifest and the
+// checksum files published next to link and returns the sums it compared.
+// The dump is hashed when rehash is set or the manifest has no sums yet, in
+// which case they are recorded in it.
+func VerifyChecksums(ctx context.Context, link, filename string, m *manifest.Manifest, rehash bool) (checksum.Sums, error) {
+	var recorded checksum.Sums
+	if m != nil {
+		recorded = m.FileSums()
+	}
+	sums := recorded
+	if rehash || len(recorded.SHA256) == 0 {
+		var err error
+		if sums, err = checksum.File(filename); err != nil {
+			return sums, err
+		}
+		if _, err := recorded.Compare(sums); err != nil {
+			return sums, fmt.Errorf("%s does not match its manifest: %w", filepath.Base(filename), err)
+		}
+		if m != nil && len(recorded.SHA256) == 0 {
+			m.SetSums(sums)
+		}
+	}
+	published := checksum.Published(ctx, link)
+	if err := ctx.Err(); err != nil {
+		return sums, err
+	}
+	compared, err := sums.Compare(published)
+	if err != nil {
+		return sums, fmt.Errorf("%s does not match the published checksum: %w", filepath.Base(filename), err)
+	}
+	if compared {
+		fmt.Printf("%s matches the published checksum\n", filepath.Base(filename))
+	}
+	return sums, nil
+}
+
+// ExtractDump unpacks the dump archive next to it, the CRCs of the archive
+// are checked while extracting.
+func ExtractDump(ctx context.Context, filename string) ([]extract.File, error) {
+	fmt.Printf("Extracting %s\n", filepath.Base(filename))
+	files, err := extract.Archive(ctx, filename, filepath.Dir(filename), printProgress("Extracted"))
+	for _, file := range files {
+		fmt.Printf("Extracted %s (%s)\n", file.Path, utils.FormatBytes(file.Size))
+	}
+	return files, err
+}
+
+// ImportDump streams the SQL scripts of the dump archive into imp straight
+// out of the archive, the scripts never touch the disk.
+func ImportDump(ctx context.Context, filename string, imp importer.Importer) error {
+	fmt.Printf("Importing %s\n", filepath.Base(filename))
+	return streamDump(ctx, filename, "Imported", imp)
+}
+
+// ExportDump writes the tables of a dump to files with the exporter.
+func ExportDump(ctx context.Context, filename string, exp *export.Exporter) error {
+	fmt.Printf("Exporting %s\n", filepath.Base(filename))
+	return streamDump(ctx, filename, "Exported", exp)
+}
+
+// GetSnapshotDir returns the directory of the record indexes and changesets
+// of a family.
+func GetSnapshotDir(family *dumps.Family) string {
+	dir := filepath.Join(GetFamilyDir(family), SnapshotDir)
+	if !utils.Exists(dir) {
+		os.MkdirAll(dir, 0755)
+	}
+	return dir
+}
+
+// previousIndex returns the newest record index in dir older than the
+// snapshot name.
+func previousIndex(dir, name string) string {
+	previous := ""
+	for _, inf := range utils.GetInfosFromDir(dir) {
+		indexed := strings.TrimSuffix(inf.Info.Name(), snapshot.IndexExt)
+		if inf.Info.IsDir() || indexed == inf.Info.Name() || indexed >= name {
+			continue
+		}
+		if indexed > previous {
+			previous = indexed
+		}
+	}
+	if len(previous) == 0 {
+		return ""
+	}
+	return snapshot.IndexPath(dir, previous)
+}
+
+// IndexDump writes the record index of a complete dump unless it exists.
+func IndexDump(ctx context.Context, family *dumps.Family, filename string) {
+	name := family.Find(filepath.Base(filename))
+	if len(name) == 0 || !strings.EqualFold(filepath.Ext(filename), ".rar") || !utils.Exists(filename) || utils.Exists(snapshot.IndexPath(GetSnapshotDir(family), name)) {
+		return
+	}
+	if m, err := manifest.Load(manifest.PathFor(filename)); err == nil && !m.Complete {
+		return
+	}
+	if err := DiffDump(ctx, filename); err != nil {
+		fmt.Printf("Failed to index %s: %v\n", filepath.Base(filename), err)
+	}
+}
+
+// DiffDump indexes the records of a dump and writes its changeset against the
+// previous snapshot of its family, older indexes are removed once it is
+// written.
+func DiffDump(ctx context.Context, filename string) error {
+	family := findFamily(filepath.Base(filename))
+	if family == nil {
+		return fmt.Errorf("%s is not a known dump", filename)
+	}
+	name := family.Find(filepath.Base(filename))
+	dir := GetSnapshotDir(family)
+	var base *snapshot.Index
+	if previous := previousIndex(dir, name); len(previous) > 0 {
+		index, err := snapshot.LoadIndex(previous)
+		if err != nil {
+			return err
+		}
+		base = index
+		fmt.Printf("Comparing %s with %s\n", name, base.Snapshot)
+	} else {
+		fmt.Printf("No snapshot before %s, indexing it only\n", name)
+	}
+	differ, err := snapshot.NewDiffer(dir, name, base)
+	if err != nil {
+		return err
+	}
+	if err = streamDump(ctx, filename, "Indexed", differ); err != nil {
+		return err
+	}
+	for _, table := range differ.Changes() {
+		fmt.Printf("%-20s %12d rows", table.Table, table.Rows)
+		if base != nil {
+			fmt.Printf(" %10d added %10d changed %10d removed", table.Added, table.Changed, table.Removed)
+		}
+		fmt.Println()
+	}
+	if base == nil {
+		return nil
+	}
+	fmt.Printf("Changes written to %s\n", snapshot.ChangesetPath(dir, name))
+	for previous := previousIndex(dir, name); len(previous) > 0; previous = previousIndex(dir, name) {
+		if err := os.Remove(previous); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Retention is how many dumps of a family are kept once a newer one is
+// downloaded and verified.
+var Retention = config.Retention{Keep: 2}
+
+// LocalDumps returns the dumps of a family found in its directory, newest
+// first, with the files and directories named after each of them.
+func LocalDumps(family *dumps.Family) ([]string, map[string][]string) {
+	names := []string{}
+	files := map[string][]string{}
+	dir := GetFamilyDir(family)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return names, files
+	}
+	for _, entry := range entries {
+		name := family.Find(entry.Name())
+		if len(name) == 0 || entry.Name() == SnapshotDir {
+			continue
+		}
+		if _, ok := files[name]; !ok {
+			names = append(names, name)
+		}
+		files[name] = append(files[name], filepath.Join(dir, entry.Name()))
+	}
+	return family.Latest(names), files
+}
+
+// IsDumpComplete reports whether a dump of the family was downloaded and
+// verified, dumps downloaded before manifests were kept count when merged.
+func IsDumpComplete(family *dumps.Family, name string) bool {
+	file := filepath.Join(GetFamilyDir(family), name+".rar")
+	if !utils.Exists(file) {
+		return false
+	}
+	if !utils.Exists(manifest.PathFor(file)) {
+		return true
+	}
+	m, err := manifest.Load(manifest.PathFor(file))
+	return err == nil && m.Complete
+}
+
+// PruneDumps removes the dumps of a family the retention policy no longer
+// keeps. Nothing is removed before a dump of the family is complete, and
+// the newest complete dump and downloads newer than it are always kept.
+func PruneDumps(family *dumps.Family) []string {
+	policy := Retention.For(family.Name)
+	names, files := LocalDumps(family)
+	newest := -1
+	for i, name := range names {
+		if IsDumpComplete(family, name) {
+			newest = i
+			break
+		}
+	}
+	removed := []string{}
+	if newest < 0 {
+		return removed
+	}
+	kept := 1
+	for _, name := range names[newest+1:] {
+		if kept < policy.Keep && IsDumpComplete(family, name) {
+			kept++
+			continue
+		}
+		if date, ok := family.Date(name); ok && policy.Days > 0 && time.Since(date) < time.Duration(policy.Days)*24*time.Hour {
+			continue
+		}
+		fmt.Printf("Removing %s, %s is complete\n", name, names[newest])
+		for _, path := range files[name] {
+			if err := os.RemoveAll(path); err != nil {
+				fmt.Printf("Failed to remove %s: %v\n", path, err)
+			}
+		}
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// streamDump streams the SQL scripts of a dump into imp and prints the rows
+// of every table.
+func streamDump(ctx context.Context, filename, verb string, imp importer.Importer) error {
+	dump := filepath.Base(filename)
+	err := extract.Stream(ctx, filename, importer.IsScript, printProgress(verb), func(name string, r io.Reader) error {
+		return imp.Import(dump+"/"+name, r)
+	})
+	if closeErr := imp.Close(err); err == nil {
+		err = closeErr
+	}
+	if summarizer, ok := imp.(importer.Summarizer); ok {
+		for _, table := range summarizer.Summary() {
+			fmt.Printf("%-20s %12d rows", table.Table, table.Rows)
+			if table.Skipped > 0 {
+				fmt.Printf(" (%d imported before)", table.Skipped)
+			}
+			fmt.Println()
+		}
+	}
+	return err
+}
+
+// printProgress prints the progress of an archive member every few seconds.
+func printProgress(verb string) extract.Progress {
+	start := time.Now()
+	return func(name string, written, total int64) {
+		if time.Since(start) < time.Second*5 {
+			return
+		}
+		start = time.Now()
+		if total > 0 {
+			progress := (float64(written) * 100) / float64(total)
+			fmt.Printf("%s %s (%s/%s) :Progress %.2f%%\n", verb, name, utils.FormatBytes(written), utils.FormatBytes(total), progress)
+		} else {
+			fmt.Printf("%s %s (%s)\n", verb, name, utils.FormatBytes(written))
+		}
+	}
+}
 func GetSortedParts(filename string) ([]string, error) {
 	result := make([]string, 0, 10)
-	rgx := regexp.MustCompile(LibGenFullRgx)
-	prefix := rgx.FindString(filename)
+	prefix := utils.RemoveExt(filepath.Base(filename))
 
 	dlrgx := regexp.MustCompile(`(-part-\d+.rar)$`)
 	digitRgx := regexp.MustCompile(`\D+`)
@@ -488,7 +1143,7 @@ func GetSortedParts(filename string) ([]string, error) {
 	parts := map[int]string{}
 	size := int64(0)
 
-	for _, part := range utils.GetInfosFromDir(GetAssetDir()) {
+	for _, part := range utils.GetInfosFromDir(filepath.Dir(filename)) {
 		if strings.HasPrefix(filepath.Base(part.FullPath), prefix) && dlrgx.MatchString(part.FullPath) {
 
 			idxStr := dlrgx.FindString(part.FullPath)
@@ -529,11 +1184,10 @@ func GetSortedParts(filename string) ([]string, error) {
 	return result, nil
 }
 func VerifyCompletion(filename string, total int64) bool {
-	rgx := regexp.MustCompile(LibGenFullRgx)
 	dlrgx := regexp.MustCompile(`(-part-\d+.rar)$`)
-	prefix := rgx.FindString(filename)
+	prefix := utils.RemoveExt(filepath.Base(filename))
 	downloaded := int64(0)
-	for _, part := range utils.GetInfosFromDir(GetAssetDir()) {
+	for _, part := range utils.GetInfosFromDir(filepath.Dir(filename)) {
 		if strings.HasPrefix(filepath.Base(part.FullPath), prefix) && dlrgx.MatchString(part.FullPath) {
 			downloaded += part.Info.Size()
 		}
@@ -541,42 +1195,64 @@ func VerifyCompletion(filename string, total int64) bool {
 
 	return downloaded == total
 }
-func Start() bool {
+func Start(ctx context.Context, family *dumps.Family) bool {
+
+	link, size := GetDumpToDownload(ctx, family)
+	return DownloadDump(ctx, family, link, size)
+}
+
+// dumpEvent describes what happened to the dump of a family downloaded from
+// link to destFile.
+func dumpEvent(event string, family *dumps.Family, destFile, link string, size int64) hooks.Event {
+	return hooks.Event{Event: event, Family: family.Name, Dump: family.Find(filepath.Base(destFile)), Path: destFile, Url: link, Size: size}
+}
 
-	link, size := GetDumpToDownload()
+// DownloadDump downloads the dump at link in parts and merges them into the
+// family directory. When ctx is done the requests in flight are aborted, the
+// parts are synced and the manifest is saved so the next run resumes.
+func DownloadDump(ctx context.Context, family *dumps.Family, link string, size int64) bool {
 	if size > 0 {
-		const partSize = 1024 * 1024 * 20
+		partSize := PartSize
 		filename := ""
 		slashIdx := strings.LastIndex(link, "/")
 		filename = link[slashIdx+1:]
-		destFile := filepath.Join(GetAssetDir(), filename)
-
-		parts := SplitFileParts(size, partSize)
-
-		downloaded := int64(0)
-
-		var asset = GetAssetDir()
-		dlrgx := regexp.MustCompile(`(-part-\d+.rar)$`)
-		digitRgx := regexp.MustCompile(`\D+`)
+		destFile := filepath.Join(GetFamilyDir(family), filename)
+		m := OpenManifest(ctx, destFile, link, size)
+		if ctx.Err() != nil {
+			return false
+		}
+		if m.Complete && utils.GetFileSize(destFile) == size {
+			fmt.Printf("%s is already downloaded\n", filename)
+			return true
+		}
+		partSize = m.PartSize
 
-		for _, inf := range utils.GetInfosFromDir(asset) {
-			if !inf.Info.IsDir() && dlrgx.MatchString(inf.FullPath) {
-				downloaded += inf.Info.Size()
-				idxStr := dlrgx.FindString(inf.FullPath)
-				idxStr = digitRgx.ReplaceAllString(idxStr, "")
-				if num, err := strconv.ParseInt(idxStr, 10, 32); err == nil {
-					k := int(num) - 1
-					delete(parts, k)
-				}
+		parts := map[int]Part{}
+		for _, p := range m.Pending() {
+			parts[p.Index] = Part{
+				Start: p.Start,
+				Size:  p.Size,
+			}
+		}
 
+		_, downloaded := m.Done()
+		var direct *os.File
+		if m.Direct {
+			var err error
+			if direct, err = OpenDirectFile(destFile, size); err != nil {
+				fmt.Printf("Failed to open %s: %v\n", destFile, err)
+				return false
 			}
+			defer direct.Close()
 		}
-		// {
-		// 	err := DownloadPart(destFile, link, 265, 2048*10, 1024*1024*5)
-		// 	fmt.Println(err)
-		// }
-		wg := sync.WaitGroup{}
-		for len(parts) > 0 {
+		pool := NewMirrorPool(ctx, link, size)
+		links := pool.Links()
+		fmt.Printf("Downloading from %d mirror(s)\n", len(links))
+		work := workers.New(MinConcurrency*len(links), MaxConcurrency*len(links), Concurrency*len(links))
+		done := atomic.Int64{}
+		done.Store(downloaded)
+		changed := atomic.Bool{}
+		for stalled := 0; stalled < StalledRounds && len(parts) > 0 && !changed.Load() && ctx.Err() == nil; {
 
 			keys := make([]int, 0, len(parts))
 
@@ -588,53 +1264,140 @@ func Start() bool {
 			total := len(parts)
 			fmt.Printf("Downloading %d parts\n", total)
 
-			downloading := 0
 			start := time.Now()
-			for _, idx := range keys {
-				// if slices.Contains(downloadedIndexes, idx+1) {
-				// 	continue
-				// }
-				wg.Add(1)
-				p := parts[idx]
-				downloading++
-				go func(index int, part Part) {
-					defer func() {
-						downloading--
-
-						wg.Done()
-
-					}()
-					err := DownloadPart(destFile, link, index, part.Start, part.Size)
+			// the workers delete the parts they finish from the map
+			pending := make([]Part, len(keys))
+			for i, idx := range keys {
+				pending[i] = parts[idx]
+			}
+			for i, idx := range keys {
+				if changed.Load() {
+					break
+				}
+				index, part := idx, pending[i]
+				err := work.Go(ctx, func() (int64, error) {
+					var sum checksum.Sums
+					var err error
+					if direct != nil {
+						sum, err = DownloadPartAt(ctx, pool, direct, part.Start, part.Size)
+					} else {
+						sum, err = DownloadPartFromPool(ctx, pool, destFile, index, part.Start, part.Size)
+					}
 					if err == nil {
+						m.SetPart(index, manifest.PartDone, sum)
 						DeletePartMapKey(parts, index)
-						downloaded += part.Size
+						done.Add(part.Size)
+					} else if err == downloader.ErrEntityChanged {
+						changed.Store(true)
+					} else if ctx.Err() == nil {
+						event := dumpEvent(hooks.PartFailed, family, destFile, link, size)
+						event.Part, event.Error = index+1, err.Error()
+						hooks.Fire(ctx, event)
 					}
-
-				}(idx, p)
-				if time.Since(start) > (time.Second*5) && downloaded > 0 {
+					return part.Size, err
+				})
+				if err != nil {
+					break
+				}
+				if downloaded := done.Load(); time.Since(start) > (time.Second*5) && downloaded > 0 {
 					progress := float64((downloaded * 100) / size)
-					fmt.Printf("Downloaded %s/%s :progress %.2f%%\n", utils.FormatBytes(downloaded), utils.FormatBytes(size), progress)
+					fmt.Printf("Downloaded %s/%s :progress %.2f%%, %d parts at once\n", utils.FormatBytes(downloaded), utils.FormatBytes(size), progress, work.Limit())
 					start = time.Now()
 				}
-				for downloading > 4 {
-					time.Sleep(time.Second * 2)
-				}
 
 			}
-			wg.Wait()
-			time.Sleep(time.Second * 2)
+			work.Wait()
+			if len(parts) < total {
+				stalled = 0
+			} else {
+				stalled++
+			}
+			utils.Sleep(ctx, time.Second*2)
 		}
-		wg.Wait()
-		if !VerifyPartsFromNetwork(link, destFile, size, partSize) {
+		if ctx.Err() != nil {
+			if err := m.Save(); err != nil {
+				fmt.Printf("Failed to write %s: %v\n", m.Path(), err)
+			}
+			done, doneBytes := m.Done()
+			fmt.Printf("Stopped %s at %d/%d parts (%s), the download resumes from there\n", filename, done, len(m.Parts), utils.FormatBytes(doneBytes))
 			return false
 		}
-		if VerifyBytes(destFile) {
-			return CleanDownloadedParts()
-		} else if VerifyCompletion(destFile, size) {
-			err := MergeParts(destFile)
-			if err == nil {
-				return CleanDownloadedParts()
+		if changed.Load() {
+			fmt.Printf("%s changed on the mirror, restarting the download\n", filename)
+			CleanDownloadedParts(destFile)
+			os.Remove(destFile)
+			os.Remove(m.Path())
+			return false
+		}
+		if len(parts) > 0 {
+			if err := m.Save(); err != nil {
+				fmt.Printf("Failed to write %s: %v\n", m.Path(), err)
+			}
+			fmt.Printf("%d parts of %s failed %d rounds in a row, the download resumes from there\n", len(parts), filename, StalledRounds)
+			return false
+		}
+		merged := false
+		if direct != nil {
+			direct.Close()
+			if !VerifyFileFromNetwork(ctx, link, destFile, partSize) {
+				if ctx.Err() != nil {
+					return false
+				}
+				hooks.Fire(ctx, dumpEvent(hooks.VerifyFailed, family, destFile, link, size))
+				return false
+			}
+			merged = true
+		} else if !VerifyPartsFromNetwork(ctx, link, destFile, size, partSize) {
+			if ctx.Err() != nil {
+				return false
 			}
+			hooks.Fire(ctx, dumpEvent(hooks.VerifyFailed, family, destFile, link, size))
+			return false
+		} else {
+			merged = VerifyBytes(destFile)
+		}
+		if !merged && VerifyCompletion(destFile, size) {
+			err := MergeParts(ctx, destFile)
+			if ctx.Err() != nil {
+				return false
+			}
+			if err != nil {
+				fmt.Printf("Failed to merge %s: %v\n", filename, err)
+			}
+			merged = err == nil
+		}
+		if merged {
+			hooks.Fire(ctx, dumpEvent(hooks.Merged, family, destFile, link, size))
+			// VerifyFileFromNetwork only samples a direct download, it is
+			// hashed as a whole to check it against the published checksum
+			if _, err := VerifyChecksums(ctx, link, destFile, m, direct != nil); err != nil {
+				if ctx.Err() != nil {
+					return false
+				}
+				fmt.Println(err)
+				event := dumpEvent(hooks.VerifyFailed, family, destFile, link, size)
+				event.Error = err.Error()
+				hooks.Fire(ctx, event)
+				CleanDownloadedParts(destFile)
+				os.Remove(destFile)
+				os.Remove(m.Path())
+				return false
+			}
+			m.SetComplete(true)
+			cleaned := CleanDownloadedParts(destFile)
+			if AutoExtract {
+				if _, err := ExtractDump(ctx, destFile); err != nil {
+					fmt.Printf("Failed to extract %s: %v\n", filename, err)
+				}
+			}
+			if Changesets {
+				if err := DiffDump(ctx, destFile); err != nil {
+					fmt.Printf("Failed to diff %s: %v\n", filename, err)
+				}
+			}
+			PruneDumps(family)
+			hooks.Fire(ctx, dumpEvent(hooks.Complete, family, destFile, link, size))
+			return cleaned
 		}
 
 	}
@@ -667,19 +1430,3 @@ func SplitFileParts(totalSize int64, partSize int) map[int]Part {
 
 	return res
 }
-func main() {
-	if utils.FirstInstance() && !utils.Exists(downloadedSignalFile) {
-		completed := Start()
-		for !completed {
-			time.Sleep(time.Second * 10)
-			completed = Start()
-		}
-		if completed {
-			utils.WriteFile(downloadedSignalFile, []byte(""))
-		}
-
-	} else {
-		fmt.Println("Another instance is running")
-		time.Sleep(time.Second * 10)
-	}
-}
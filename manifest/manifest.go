@@ -0,0 +1,269 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"libgen/checksum"
+	"libgen/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PartState string
+
+const (
+	PartPending PartState = "pending"
+	PartDone    PartState = "done"
+)
+
+// Suffix is appended to the dump file name to get its manifest.
+const Suffix = ".manifest.json"
+
+type Part struct {
+	Index int       `json:"index"`
+	Start int64     `json:"start"`
+	Size  int64     `json:"size"`
+	State PartState `json:"state"`
+	checksum.Sums
+}
+
+// Manifest describes a dump download so it can be resumed exactly after a
+// crash and so a dump republished under the same name is noticed.
+type Manifest struct {
+	Url          string `json:"url"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	PartSize     int64  `json:"part_size"`
+	// Direct downloads write every part at its offset in the preallocated
+	// dump file, the part states are then the map of completed ranges.
+	Direct   bool      `json:"direct,omitempty"`
+	Parts    []Part    `json:"parts"`
+	Complete bool      `json:"complete"`
+	Updated  time.Time `json:"updated"`
+	// Sums are the checksums of the merged dump.
+	checksum.Sums
+	// Steps are the watch steps that finished on the complete dump.
+	Steps []string `json:"steps,omitempty"`
+
+	path string
+	lck  sync.Mutex
+}
+
+// PathFor returns the manifest file of a dump file.
+func PathFor(destFile string) string {
+	return destFile + Suffix
+}
+
+// DumpFile returns the dump file a manifest file belongs to.
+func DumpFile(path string) string {
+	return strings.TrimSuffix(path, Suffix)
+}
+
+func New(path, url string, size, partSize int64) *Manifest {
+	m := Manifest{
+		Url:      url,
+		Size:     size,
+		PartSize: partSize,
+		Parts:    make([]Part, 0, size/partSize+1),
+		path:     path,
+	}
+	for start, index := int64(0), 0; start < size; start, index = start+partSize, index+1 {
+		partLen := partSize
+		if start+partLen > size {
+			partLen = size - start
+		}
+		m.Parts = append(m.Parts, Part{
+			Index: index,
+			Start: start,
+			Size:  partLen,
+			State: PartPending,
+		})
+	}
+	return &m
+}
+
+func Load(path string) (*Manifest, error) {
+	data, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := Manifest{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.Size <= 0 || m.PartSize <= 0 {
+		return nil, errors.New("invalid manifest")
+	}
+	m.path = path
+	return &m, nil
+}
+
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+// Save writes the manifest to a temporary file and renames it over the old
+// one so a crash never leaves a half written manifest behind.
+func (m *Manifest) Save() error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	return m.save()
+}
+func (m *Manifest) save() error {
+	m.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".new"
+	file, err := os.OpenFile(tmp, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// SetPart records the state of a part and saves the manifest.
+func (m *Manifest) SetPart(index int, state PartState, sums checksum.Sums) error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if index < 0 || index >= len(m.Parts) {
+		return errors.New("part index out of range")
+	}
+	m.Parts[index].State = state
+	m.Parts[index].Sums = sums
+	return m.save()
+}
+
+// Part returns a copy of the part at index.
+func (m *Manifest) Part(index int) (Part, bool) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if index < 0 || index >= len(m.Parts) {
+		return Part{}, false
+	}
+	return m.Parts[index], true
+}
+
+// SetSums records the checksums of the merged dump and saves the manifest.
+func (m *Manifest) SetSums(sums checksum.Sums) error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	m.Sums = sums
+	return m.save()
+}
+
+func (m *Manifest) FileSums() checksum.Sums {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	return m.Sums
+}
+
+// SetComplete marks the dump as merged and verified and saves the manifest.
+func (m *Manifest) SetComplete(complete bool) error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	m.Complete = complete
+	if !complete {
+		m.Sums = checksum.Sums{}
+		m.Steps = nil
+	}
+	return m.save()
+}
+
+// StepDone reports whether a watch step finished on the dump.
+func (m *Manifest) StepDone(step string) bool {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	for _, s := range m.Steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStepDone records that a watch step finished and saves the manifest.
+func (m *Manifest) SetStepDone(step string) error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	m.Steps = append(m.Steps, step)
+	return m.save()
+}
+
+// Pending returns the parts that still have to be downloaded.
+func (m *Manifest) Pending() []Part {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	res := make([]Part, 0, len(m.Parts))
+	for _, p := range m.Parts {
+		if p.State != PartDone {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Bitmap returns the part states as a string of 1 for a downloaded part and
+// 0 for a pending one.
+func (m *Manifest) Bitmap() string {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	bits := make([]byte, len(m.Parts))
+	for i, p := range m.Parts {
+		bits[i] = '0'
+		if p.State == PartDone {
+			bits[i] = '1'
+		}
+	}
+	return string(bits)
+}
+
+// Done returns the number of downloaded parts and their size.
+func (m *Manifest) Done() (int, int64) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	count, size := 0, int64(0)
+	for _, p := range m.Parts {
+		if p.State == PartDone {
+			count++
+			size += p.Size
+		}
+	}
+	return count, size
+}
+
+// Changed reports whether the remote file differs from the one the manifest
+// was written for. Validators are only compared on the same url since every
+// mirror computes its own ETag and stamps its copy with its own
+// Last-Modified, across mirrors only the size is compared.
+func (m *Manifest) Changed(url string, size int64, etag, lastModified string) bool {
+	if m.Size != size {
+		return true
+	}
+	if url != m.Url {
+		return false
+	}
+	if len(etag) > 0 && len(m.ETag) > 0 && etag != m.ETag {
+		return true
+	}
+	if len(lastModified) > 0 && len(m.LastModified) > 0 && lastModified != m.LastModified {
+		return true
+	}
+	return false
+}
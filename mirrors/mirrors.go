@@ -0,0 +1,165 @@
+package mirrors
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DefaultMirrors = []string{"https://data.library.bz/dbdumps/"}
+
+// MaxFailures is the number of consecutive failures after which a mirror is
+// considered dead and skipped until its cool-down expires.
+var MaxFailures = 3
+var Cooldown = time.Minute * 10
+
+type Mirror struct {
+	Url       string
+	Failures  int
+	DownUntil time.Time
+}
+
+var lck sync.Mutex
+var mirrors = newMirrors(DefaultMirrors)
+
+func newMirrors(urls []string) []*Mirror {
+	list := make([]*Mirror, 0, len(urls))
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if len(u) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(u, "/") {
+			u += "/"
+		}
+		list = append(list, &Mirror{Url: u})
+	}
+	return list
+}
+
+// Set replaces the mirror list, the order of urls is the order of preference.
+func Set(urls []string) {
+	list := newMirrors(urls)
+	if len(list) == 0 {
+		list = newMirrors(DefaultMirrors)
+	}
+	lck.Lock()
+	defer lck.Unlock()
+	mirrors = list
+}
+
+// Available returns the mirrors that are not cooling down in order of
+// preference. When every mirror is down they are all returned, the one that
+// recovers first leading, so callers always have something to try.
+func Available() []Mirror {
+	lck.Lock()
+	defer lck.Unlock()
+	now := time.Now()
+	res := make([]Mirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		if now.After(m.DownUntil) {
+			res = append(res, *m)
+		}
+	}
+	if len(res) > 0 {
+		return res
+	}
+	for _, m := range mirrors {
+		res = append(res, *m)
+	}
+	for i := 1; i < len(res); i++ {
+		for j := i; j > 0 && res[j].DownUntil.Before(res[j-1].DownUntil); j-- {
+			res[j], res[j-1] = res[j-1], res[j]
+		}
+	}
+	return res
+}
+func find(link string) *Mirror {
+	for _, m := range mirrors {
+		if strings.HasPrefix(link, m.Url) {
+			return m
+		}
+	}
+	return nil
+}
+
+func isDown(link string) bool {
+	lck.Lock()
+	defer lck.Unlock()
+	m := find(link)
+	return m != nil && time.Now().Before(m.DownUntil)
+}
+
+// MarkFailed records a failed request against the mirror serving link.
+func MarkFailed(link string) {
+	lck.Lock()
+	defer lck.Unlock()
+	m := find(link)
+	if m == nil {
+		return
+	}
+	m.Failures++
+	if m.Failures >= MaxFailures {
+		m.DownUntil = time.Now().Add(Cooldown)
+		m.Failures = 0
+	}
+}
+
+// MarkDown puts the mirror serving link in cool-down straight away.
+func MarkDown(link string) {
+	lck.Lock()
+	defer lck.Unlock()
+	m := find(link)
+	if m == nil {
+		return
+	}
+	m.Failures = 0
+	m.DownUntil = time.Now().Add(Cooldown)
+}
+
+// MarkHealthy clears the failure count of the mirror serving link.
+func MarkHealthy(link string) {
+	lck.Lock()
+	defer lck.Unlock()
+	m := find(link)
+	if m == nil {
+		return
+	}
+	m.Failures = 0
+	m.DownUntil = time.Time{}
+}
+
+// Name returns the file part of a dump link or name.
+func Name(link string) string {
+	if u, err := url.Parse(link); err == nil && len(u.Path) > 0 {
+		return path.Base(u.Path)
+	}
+	return path.Base(link)
+}
+
+// Link returns the url of the dump name on the mirror base.
+func Link(base, name string) string {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base + Name(name)
+}
+
+// Links returns link on every available mirror, its own mirror first when it
+// is available, so a failed request can fail over to the next entry.
+func Links(link string) []string {
+	res := make([]string, 0, 4)
+	for _, m := range Available() {
+		if strings.HasPrefix(link, m.Url) {
+			res = append([]string{link}, res...)
+		} else {
+			res = append(res, Link(m.Url, link))
+		}
+	}
+	if len(res) == 0 {
+		res = append(res, link)
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+package mirrors
+
+import (
+	"sync"
+	"time"
+)
+
+// Source is a mirror url of a single dump together with the throughput it
+// achieved so far.
+type Source struct {
+	Url string
+	// Validator is the If-Range value of the dump on this mirror.
+	Validator string
+	Bytes     int64
+	Elapsed   time.Duration
+	Inflight  int
+}
+
+func (s *Source) rate() float64 {
+	if s.Bytes == 0 || s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// Pool spreads the parts of a dump over every mirror hosting it, faster
+// mirrors are handed more parts.
+type Pool struct {
+	lck     sync.Mutex
+	sources []*Source
+}
+
+func NewPool(links []string) *Pool {
+	pool := Pool{sources: make([]*Source, 0, len(links))}
+	for _, link := range links {
+		pool.sources = append(pool.sources, &Source{Url: link})
+	}
+	return &pool
+}
+func (p *Pool) Links() []string {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	links := make([]string, 0, len(p.sources))
+	for _, s := range p.sources {
+		links = append(links, s.Url)
+	}
+	return links
+}
+func (p *Pool) SetValidator(link, validator string) {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	for _, s := range p.sources {
+		if s.Url == link {
+			s.Validator = validator
+		}
+	}
+}
+func (p *Pool) Validator(link string) string {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	for _, s := range p.sources {
+		if s.Url == link {
+			return s.Validator
+		}
+	}
+	return ""
+}
+func (p *Pool) candidates(exclude map[string]bool) []*Source {
+	res := make([]*Source, 0, len(p.sources))
+	for _, s := range p.sources {
+		if !exclude[s.Url] && !isDown(s.Url) {
+			res = append(res, s)
+		}
+	}
+	if len(res) == 0 {
+		for _, s := range p.sources {
+			if !exclude[s.Url] {
+				res = append(res, s)
+			}
+		}
+	}
+	if len(res) == 0 {
+		res = append(res, p.sources...)
+	}
+	return res
+}
+
+// Pick returns the source expected to finish one more part the soonest,
+// skipping the urls in exclude unless nothing else is left. Every Pick must be
+// followed by a Report or a Release for the same url.
+func (p *Pool) Pick(exclude map[string]bool) string {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	if len(p.sources) == 0 {
+		return ""
+	}
+	sources := p.candidates(exclude)
+
+	// mirrors that have not delivered anything yet are assumed to be as fast
+	// as the best one so they get a chance to be measured
+	best := 0.0
+	for _, s := range sources {
+		if r := s.rate(); r > best {
+			best = r
+		}
+	}
+	if best == 0 {
+		best = 1
+	}
+	var picked *Source
+	score := 0.0
+	for _, s := range sources {
+		r := s.rate()
+		if r == 0 {
+			r = best
+		}
+		sc := float64(s.Inflight+1) / r
+		if picked == nil || sc < score {
+			picked = s
+			score = sc
+		}
+	}
+	picked.Inflight++
+	return picked.Url
+}
+
+// Report records the outcome of a transfer of n bytes from link.
+func (p *Pool) Report(link string, n int64, elapsed time.Duration, err error) {
+	p.lck.Lock()
+	if s := p.release(link); s != nil && err == nil {
+		s.Bytes += n
+		s.Elapsed += elapsed
+	}
+	p.lck.Unlock()
+	if err == nil {
+		MarkHealthy(link)
+	} else {
+		MarkFailed(link)
+	}
+}
+
+// Release ends a transfer from link that was aborted without saying anything
+// about the mirror, e.g. because the download was stopped.
+func (p *Pool) Release(link string) {
+	p.lck.Lock()
+	p.release(link)
+	p.lck.Unlock()
+}
+
+func (p *Pool) release(link string) *Source {
+	for _, s := range p.sources {
+		if s.Url == link {
+			if s.Inflight > 0 {
+				s.Inflight--
+			}
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"libgen/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy caps the bandwidth every download shares. Limit applies outside the
+// windows of the schedule, an empty limit or "0" leaves it unlimited.
+type Policy struct {
+	Limit    string   `json:"limit"`
+	Schedule []Window `json:"schedule"`
+}
+
+// Window sets the limit from From to To, e.g. 09:00 to 18:00. A window
+// ending before it starts runs past midnight, Days restricts it to some
+// days of the week, e.g. ["mon", "tue"]. The first matching window applies.
+type Window struct {
+	Days  []string `json:"days"`
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Limit string   `json:"limit"`
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type window struct {
+	days     uint8
+	from, to int
+	rate     int64
+}
+
+func (w *window) matches(t time.Time) bool {
+	if w.days&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return minute >= w.from && minute < w.to
+	}
+	return minute >= w.from || minute < w.to
+}
+
+// limiter is a token bucket holding up to a second of traffic. Readers take
+// the bytes they read and sleep off the debt once the bucket is empty.
+type limiter struct {
+	lck     sync.Mutex
+	rate    int64
+	windows []window
+	tokens  float64
+	last    time.Time
+}
+
+var shared = limiter{}
+
+// Set validates the policy and applies it to the downloads in progress.
+func Set(p Policy) error {
+	rate, err := parseRate(p.Limit)
+	if err != nil {
+		return err
+	}
+	windows := make([]window, 0, len(p.Schedule))
+	for i, w := range p.Schedule {
+		parsed, err := parseWindow(w)
+		if err != nil {
+			return fmt.Errorf("window %d: %w", i+1, err)
+		}
+		windows = append(windows, parsed)
+	}
+	shared.lck.Lock()
+	shared.rate, shared.windows = rate, windows
+	shared.lck.Unlock()
+	return nil
+}
+
+func parseWindow(w Window) (window, error) {
+	res := window{}
+	var err error
+	if res.from, err = parseClock(w.From); err != nil {
+		return res, err
+	}
+	if res.to, err = parseClock(w.To); err != nil {
+		return res, err
+	}
+	if res.from == res.to {
+		return res, fmt.Errorf("%s to %s is empty", w.From, w.To)
+	}
+	if res.rate, err = parseRate(w.Limit); err != nil {
+		return res, err
+	}
+	if len(w.Days) == 0 {
+		res.days = 0x7f
+	}
+	for _, day := range w.Days {
+		found := false
+		for i, name := range weekdays {
+			if strings.HasPrefix(strings.ToLower(day), name) {
+				res.days |= 1 << uint(i)
+				found = true
+			}
+		}
+		if !found {
+			return res, fmt.Errorf("unknown day %q, expected one of %s", day, strings.Join(weekdays, ", "))
+		}
+	}
+	return res, nil
+}
+
+// parseClock returns the minutes since midnight of a time like 18:30.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseRate parses a limit like 1MB or 512KB/s, 0 for no limit.
+func parseRate(limit string) (int64, error) {
+	limit = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(limit)), "/s"))
+	if len(limit) == 0 || limit == "unlimited" {
+		return 0, nil
+	}
+	return utils.ParseBytes(limit)
+}
+
+// Rate returns the bytes per second allowed at t, 0 when unlimited.
+func Rate(t time.Time) int64 {
+	shared.lck.Lock()
+	defer shared.lck.Unlock()
+	return shared.rateAt(t)
+}
+
+func (l *limiter) rateAt(t time.Time) int64 {
+	for i := range l.windows {
+		if l.windows[i].matches(t) {
+			return l.windows[i].rate
+		}
+	}
+	return l.rate
+}
+
+// Wait takes n bytes from the bucket, sleeping until the bucket covers them
+// or ctx is done.
+func Wait(ctx context.Context, n int) error {
+	shared.lck.Lock()
+	now := time.Now()
+	rate := shared.rateAt(now)
+	if rate <= 0 {
+		shared.tokens, shared.last = 0, now
+		shared.lck.Unlock()
+		return nil
+	}
+	if !shared.last.IsZero() {
+		shared.tokens += now.Sub(shared.last).Seconds() * float64(rate)
+	}
+	if shared.tokens > float64(rate) {
+		shared.tokens = float64(rate)
+	}
+	shared.last = now
+	shared.tokens -= float64(n)
+	debt := -shared.tokens
+	shared.lck.Unlock()
+	if debt <= 0 {
+		return nil
+	}
+	return utils.Sleep(ctx, time.Duration(debt/float64(rate)*float64(time.Second)))
+}
+
+// chunk is the most a limited reader reads at once so that a limit of a few
+// KB/s still flows smoothly.
+const chunk = 16 * 1024
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Reader limits the reads from r to the shared bandwidth.
+func Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := Wait(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
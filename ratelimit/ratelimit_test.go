@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+const (
+	kb = 1024
+	mb = 1024 * 1024
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  int64
+		ok    bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{"unlimited", 0, true},
+		{"1MB", mb, true},
+		{"512KB/s", 512 * kb, true},
+		{" 2 mb/s ", 2 * mb, true},
+		{"1.5k", 1536, true},
+		{"100", 100, true},
+		{"NaN", 0, false},
+		{"nan/s", 0, false},
+		{"Inf", 0, false},
+		{"+Inf MB", 0, false},
+		{"1e400", 0, false},
+		{"1e300PB", 0, false},
+		{"1e19", 0, false},
+		{"-1MB", 0, false},
+		{"fast", 0, false},
+		{"1 parsec", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseRate(test.limit)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseRate(%q) = %d, %v, want %d ok=%v", test.limit, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	defer Set(Policy{})
+	err := Set(Policy{
+		Limit: "1MB",
+		Schedule: []Window{
+			{Days: []string{"mon", "Tuesday", "wed", "thu", "fri"}, From: "09:00", To: "18:00", Limit: "100KB"},
+			// past midnight, every day
+			{From: "22:00", To: "06:00", Limit: "unlimited"},
+			// hidden by the first window on weekdays
+			{From: "12:00", To: "13:00", Limit: "10KB"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2023-09-04 is a monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2023, 9, day, hour, minute, 30, 0, time.Local)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want int64
+	}{
+		{"monday morning", at(4, 9, 0), 100 * kb},
+		{"monday afternoon", at(4, 17, 59), 100 * kb},
+		{"monday evening", at(4, 18, 0), mb},
+		{"before work", at(5, 8, 59), mb},
+		{"saturday", at(9, 10, 0), mb},
+		{"saturday lunch", at(9, 12, 30), 10 * kb},
+		{"weekday lunch", at(6, 12, 30), 100 * kb},
+		{"night", at(5, 23, 30), 0},
+		{"midnight", at(6, 0, 0), 0},
+		{"early morning", at(6, 5, 59), 0},
+		{"end of the night", at(6, 6, 0), mb},
+		{"sunday night", at(10, 22, 0), 0},
+	}
+	for _, test := range tests {
+		if got := Rate(test.t); got != test.want {
+			t.Errorf("%s: Rate(%s) = %d, want %d", test.name, test.t.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	defer Set(Policy{})
+	if err := Set(Policy{Limit: "2MB"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []Policy{
+		{Limit: "NaN"},
+		{Limit: "Inf"},
+		{Schedule: []Window{{From: "09:00", To: "09:00", Limit: "1MB"}}},
+		{Schedule: []Window{{From: "25:00", To: "09:00", Limit: "1MB"}}},
+		{Schedule: []Window{{From: "9", To: "10:00", Limit: "1MB"}}},
+		{Schedule: []Window{{From: "09:00", To: "10:00", Limit: "NaN"}}},
+		{Schedule: []Window{{Days: []string{"funday"}, From: "09:00", To: "10:00", Limit: "1MB"}}},
+	}
+	for _, p := range tests {
+		if err := Set(p); err == nil {
+			t.Errorf("Set(%+v) accepted an invalid policy", p)
+		}
+	}
+	// an invalid policy leaves the current one in place
+	if got := Rate(time.Now()); got != 2*mb {
+		t.Errorf("Rate = %d after invalid policies, want %d", got, 2*mb)
+	}
+}
+
+// reset empties the bucket as if it was last used ago.
+func reset(ago time.Duration) {
+	shared.lck.Lock()
+	shared.tokens, shared.last = 0, time.Now().Add(-ago)
+	shared.lck.Unlock()
+}
+
+func TestWait(t *testing.T) {
+	defer Set(Policy{})
+	ctx := context.Background()
+	if err := Set(Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := Wait(ctx, 1<<40); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("unlimited Wait took %s: %v", time.Since(start), err)
+	}
+
+	const rate = 100 * mb
+	if err := Set(Policy{Limit: "100MB"}); err != nil {
+		t.Fatal(err)
+	}
+	// the bucket refills at the rate and holds at most a second of traffic
+	reset(5 * time.Second)
+	start = time.Now()
+	if err := Wait(ctx, rate/2); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Wait from a full bucket took %s: %v", time.Since(start), err)
+	}
+	shared.lck.Lock()
+	tokens := shared.tokens
+	shared.lck.Unlock()
+	if tokens < rate/2 || tokens > rate/2+rate/10 {
+		t.Errorf("%.0f tokens left, want about %d", tokens, rate/2)
+	}
+
+	// taking more than the bucket holds waits for the debt
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := Wait(timeout, rate); err != context.DeadlineExceeded {
+		t.Errorf("Wait = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait returned %s after ctx was done", elapsed)
+	}
+
+	// the debt is paid off over time
+	reset(0)
+	start = time.Now()
+	if err := Wait(ctx, rate/20); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Wait for 50ms of traffic took %s", elapsed)
+	}
+}
+
+func TestReader(t *testing.T) {
+	defer Set(Policy{})
+	if err := Set(Policy{Limit: "100MB"}); err != nil {
+		t.Fatal(err)
+	}
+	reset(time.Second)
+	data := bytes.Repeat([]byte("x"), 100*kb)
+	r := Reader(context.Background(), bytes.NewReader(data))
+	buf := make([]byte, 64*kb)
+	if n, err := r.Read(buf); n != chunk || err != nil {
+		t.Errorf("Read = %d, %v, want a chunk of %d", n, err, chunk)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || len(rest) != len(data)-chunk {
+		t.Errorf("read %d bytes: %v, want %d", len(rest), err, len(data)-chunk)
+	}
+}
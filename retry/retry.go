@@ -0,0 +1,162 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Policy retries an operation up to Attempts times, waiting Base after the
+// first failure and twice as long after every further one, at most Max.
+// Every wait is jittered by up to half its length so that parts failing
+// together do not retry together.
+type Policy struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+	// MaxRetryAfter caps the wait a server asks for with Retry-After.
+	MaxRetryAfter time.Duration
+}
+
+// Default is the policy of every request to the mirrors.
+var Default = Policy{Attempts: 5, Base: time.Second, Max: time.Minute, MaxRetryAfter: time.Minute * 10}
+
+// Metrics counts the attempts, retries, failures and permanent failures of
+// every operation, e.g. "part.retries". It is published with expvar.
+var Metrics = expvar.NewMap("retry")
+
+type permanent struct {
+	err error
+}
+
+func (p *permanent) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanent) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanent{err: err}
+}
+
+// IsPermanent reports whether retrying cannot help: the error was marked
+// with Permanent or the server answered 404, 410 or 416.
+func IsPermanent(err error) bool {
+	var p *permanent
+	if errors.As(err, &p) {
+		return true
+	}
+	switch StatusCode(err) {
+	case http.StatusNotFound, http.StatusGone, http.StatusRequestedRangeNotSatisfiable:
+		return true
+	}
+	return false
+}
+
+// StatusCode returns the HTTP status of an error answering a request, 0 for
+// errors that are not about a status.
+func StatusCode(err error) int {
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) {
+		return status.StatusCode()
+	}
+	return 0
+}
+
+// retryAfter returns the wait asked for by a 429 or 503 answer.
+func retryAfter(err error) time.Duration {
+	var after interface{ RetryAfter() time.Duration }
+	if code := StatusCode(err); code != http.StatusTooManyRequests && code != http.StatusServiceUnavailable {
+		return 0
+	}
+	if errors.As(err, &after) {
+		return after.RetryAfter()
+	}
+	return 0
+}
+
+// Backoff returns the wait before the attempt following a failed one.
+func (p Policy) Backoff(attempt int, err error) time.Duration {
+	wait := p.Base
+	for i := 1; i < attempt && wait < p.Max; i++ {
+		wait *= 2
+	}
+	if wait > p.Max {
+		wait = p.Max
+	}
+	if wait > 0 {
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+	if after := retryAfter(err); after > wait {
+		wait = after
+		if p.MaxRetryAfter > 0 && wait > p.MaxRetryAfter {
+			wait = p.MaxRetryAfter
+		}
+	}
+	return wait
+}
+
+// Do runs fn with the default policy, see Policy.Do.
+func Do(ctx context.Context, op, subject string, fn func(attempt int) error) error {
+	return Default.Do(ctx, op, subject, fn)
+}
+
+// Do runs fn until it succeeds, fails permanently or the attempts are used
+// up and returns the last error. attempt counts from 1. op names the
+// operation in the metrics and subject what it works on in the log. Once
+// ctx is done Do returns its error.
+func (p Policy) Do(ctx context.Context, op, subject string, fn func(attempt int) error) error {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		Metrics.Add(op+".attempts", 1)
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if IsPermanent(err) {
+			Metrics.Add(op+".permanent", 1)
+			return unwrap(err)
+		}
+		if attempt == attempts {
+			break
+		}
+		wait := p.Backoff(attempt, err)
+		fmt.Printf("%s failed (attempt %d/%d): %v, retrying in %s\n", subject, attempt, attempts, err, wait.Round(time.Millisecond*100))
+		Metrics.Add(op+".retries", 1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	Metrics.Add(op+".failures", 1)
+	if attempts > 1 {
+		fmt.Printf("%s failed after %d attempts: %v\n", subject, attempts, err)
+	}
+	return err
+}
+
+func unwrap(err error) error {
+	if p, ok := err.(*permanent); ok {
+		return p.err
+	}
+	return err
+}
@@ -0,0 +1,213 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type statusError struct {
+	code  int
+	after time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received status code %d", e.code)
+}
+
+func (e *statusError) StatusCode() int {
+	return e.code
+}
+
+func (e *statusError) RetryAfter() time.Duration {
+	return e.after
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{Base: 100 * time.Millisecond, Max: time.Second}
+	// the wait doubles from Base up to Max and is jittered down by at most half
+	longest := []time.Duration{100, 200, 400, 800, 1000, 1000, 1000}
+	for i, max := range longest {
+		attempt := i + 1
+		max *= time.Millisecond
+		for n := 0; n < 200; n++ {
+			if wait := p.Backoff(attempt, errors.New("failed")); wait < max/2 || wait > max {
+				t.Fatalf("Backoff(%d) = %s, want %s to %s", attempt, wait, max/2, max)
+			}
+		}
+	}
+	if wait := (Policy{}).Backoff(3, errors.New("failed")); wait != 0 {
+		t.Errorf("Backoff without a base = %s, want 0", wait)
+	}
+	if wait := (Policy{Base: time.Hour, Max: time.Hour}).Backoff(1000, nil); wait > time.Hour {
+		t.Errorf("Backoff(1000) = %s, want at most an hour", wait)
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	p := Policy{Base: time.Millisecond, Max: time.Millisecond, MaxRetryAfter: 2 * time.Second}
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{"503", &statusError{code: http.StatusServiceUnavailable, after: time.Second}, time.Second},
+		{"429", &statusError{code: http.StatusTooManyRequests, after: time.Second}, time.Second},
+		{"wrapped", fmt.Errorf("part 3: %w", &statusError{code: http.StatusTooManyRequests, after: time.Second}), time.Second},
+		{"capped", &statusError{code: http.StatusServiceUnavailable, after: time.Hour}, 2 * time.Second},
+		{"other status", &statusError{code: http.StatusInternalServerError, after: time.Hour}, 0},
+		{"shorter than the backoff", &statusError{code: http.StatusServiceUnavailable, after: time.Microsecond}, 0},
+	}
+	for _, test := range tests {
+		wait := p.Backoff(1, test.err)
+		if test.want == 0 {
+			if wait > time.Millisecond {
+				t.Errorf("%s: Backoff = %s, want the plain backoff", test.name, wait)
+			}
+		} else if wait != test.want {
+			t.Errorf("%s: Backoff = %s, want %s", test.name, wait, test.want)
+		}
+	}
+	p.MaxRetryAfter = 0
+	if wait := p.Backoff(1, &statusError{code: http.StatusServiceUnavailable, after: time.Hour}); wait != time.Hour {
+		t.Errorf("uncapped Backoff = %s, want an hour", wait)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("connection reset"), false},
+		{Permanent(errors.New("bad")), true},
+		{fmt.Errorf("part 1: %w", Permanent(errors.New("bad"))), true},
+		{&statusError{code: http.StatusNotFound}, true},
+		{&statusError{code: http.StatusGone}, true},
+		{&statusError{code: http.StatusRequestedRangeNotSatisfiable}, true},
+		{fmt.Errorf("part 1: %w", &statusError{code: http.StatusNotFound}), true},
+		{&statusError{code: http.StatusInternalServerError}, false},
+		{&statusError{code: http.StatusServiceUnavailable}, false},
+		{&statusError{code: http.StatusTooManyRequests}, false},
+		{&statusError{code: http.StatusForbidden}, false},
+	}
+	for _, test := range tests {
+		if got := IsPermanent(test.err); got != test.want {
+			t.Errorf("IsPermanent(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) is not nil")
+	}
+}
+
+func counter(name string) int64 {
+	if v, ok := Metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{Attempts: 4, Base: time.Microsecond, Max: time.Millisecond}
+	ctx := context.Background()
+	errBad := errors.New("bad")
+	tests := []struct {
+		name string
+		// fail returns the error of an attempt
+		fail  func(attempt int) error
+		calls int
+		err   error
+	}{
+		{"success", func(int) error { return nil }, 1, nil},
+		{"success after failures", func(attempt int) error {
+			if attempt < 3 {
+				return errBad
+			}
+			return nil
+		}, 3, nil},
+		{"attempts used up", func(int) error { return errBad }, 4, errBad},
+		{"permanent", func(int) error { return Permanent(errBad) }, 1, errBad},
+		{"404", func(int) error { return &statusError{code: http.StatusNotFound} }, 1, &statusError{code: http.StatusNotFound}},
+		{"permanent after a failure", func(attempt int) error {
+			if attempt == 1 {
+				return &statusError{code: http.StatusServiceUnavailable}
+			}
+			return &statusError{code: http.StatusGone}
+		}, 2, &statusError{code: http.StatusGone}},
+	}
+	permanents, failures := counter("test.permanent.permanent"), counter("test.attempts used up.failures")
+	for _, test := range tests {
+		op := "test." + test.name
+		attempts := counter(op + ".attempts")
+		calls := 0
+		err := p.Do(ctx, op, test.name, func(attempt int) error {
+			calls++
+			if attempt != calls {
+				t.Errorf("%s: attempt %d on call %d", test.name, attempt, calls)
+			}
+			return test.fail(attempt)
+		})
+		if calls != test.calls {
+			t.Errorf("%s: %d calls, want %d", test.name, calls, test.calls)
+		}
+		if fmt.Sprint(err) != fmt.Sprint(test.err) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+		var perm *permanent
+		if errors.As(err, &perm) {
+			t.Errorf("%s: Do returned the permanent wrapper", test.name)
+		}
+		if n := counter(op+".attempts") - attempts; n != int64(test.calls) {
+			t.Errorf("%s: %d attempts counted, want %d", test.name, n, test.calls)
+		}
+	}
+	if n := counter("test.permanent.permanent") - permanents; n != 1 {
+		t.Errorf("%d permanent failures counted, want 1", n)
+	}
+	if n := counter("test.attempts used up.failures") - failures; n != 1 {
+		t.Errorf("%d failures counted, want 1", n)
+	}
+
+	calls := 0
+	(Policy{}).Do(ctx, "test.no attempts", "no attempts", func(int) error {
+		calls++
+		return errBad
+	})
+	if calls != 1 {
+		t.Errorf("a policy without attempts called fn %d times, want 1", calls)
+	}
+}
+
+func TestDoCancel(t *testing.T) {
+	// cancelled while an attempt runs
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Policy{Attempts: 5, Base: time.Microsecond}.Do(ctx, "test.cancel", "cancel", func(int) error {
+		calls++
+		cancel()
+		return errors.New("aborted")
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("Do = %v after %d calls, want %v after 1", err, calls, context.Canceled)
+	}
+
+	// cancelled while waiting for the next attempt
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = Policy{Attempts: 5, Base: time.Hour, Max: time.Hour}.Do(ctx, "test.cancel", "cancel", func(int) error {
+		return errors.New("failed")
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Do = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Do returned %s after ctx was done", elapsed)
+	}
+}
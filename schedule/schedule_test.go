@@ -0,0 +1,102 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"0 3 * * *", true},
+		{"*/30 * * * 1-5", true},
+		{"0,15,30,45 8-18/2 1 1-12 7", true},
+		{" @daily ", true},
+		{"@WEEKLY", true},
+		{"@every 6h", true},
+		{"@every 30s", false},
+		{"@every soon", false},
+		{"@yearly", false},
+		{"", false},
+		{"0 3 * *", false},
+		{"0 3 * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1-b * * * *", false},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("Parse(%q) = %v, want ok=%v", test.spec, err, test.ok)
+			continue
+		}
+		if test.ok && s.String() == "" {
+			t.Errorf("Parse(%q) lost the spec", test.spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	// the clocks of New York went forward from 02:00 to 03:00 on 2023-03-12
+	// and back from 02:00 to 01:00 on 2023-11-05
+	edt := func(day, hour, minute int) time.Time {
+		return time.Date(2023, 11, day, hour, minute, 0, 0, time.FixedZone("EDT", -4*3600))
+	}
+	est := func(day, hour, minute int) time.Time {
+		return time.Date(2023, 11, day, hour, minute, 0, 0, time.FixedZone("EST", -5*3600))
+	}
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want []time.Time
+	}{
+		{"daily", "0 3 * * *", utc(2023, 9, 5, 12, 0), []time.Time{utc(2023, 9, 6, 3, 0), utc(2023, 9, 7, 3, 0)}},
+		{"the next minute", "* * * * *", utc(2023, 9, 5, 12, 0).Add(30 * time.Second), []time.Time{utc(2023, 9, 5, 12, 1), utc(2023, 9, 5, 12, 2)}},
+		{"step", "*/20 9 * * *", utc(2023, 9, 5, 9, 20), []time.Time{utc(2023, 9, 5, 9, 40), utc(2023, 9, 6, 9, 0)}},
+		{"end of year", "0 0 1 1 *", utc(2023, 9, 5, 0, 0), []time.Time{utc(2024, 1, 1, 0, 0), utc(2025, 1, 1, 0, 0)}},
+		{"weekdays", "0 8 * * 1-5", utc(2023, 9, 8, 9, 0), []time.Time{utc(2023, 9, 11, 8, 0), utc(2023, 9, 12, 8, 0)}},
+		{"sunday as 7", "0 0 * * 7", utc(2023, 9, 5, 0, 0), []time.Time{utc(2023, 9, 10, 0, 0), utc(2023, 9, 17, 0, 0)}},
+		// both day fields restricted: the 13th or a friday
+		{"day of month or week", "0 0 13 * 5", utc(2023, 9, 30, 0, 0), []time.Time{utc(2023, 10, 6, 0, 0), utc(2023, 10, 13, 0, 0), utc(2023, 10, 20, 0, 0)}},
+		// only the day of month restricted: every 13th
+		{"day of month", "0 0 13 * *", utc(2023, 9, 30, 0, 0), []time.Time{utc(2023, 10, 13, 0, 0), utc(2023, 11, 13, 0, 0)}},
+		{"leap day", "0 0 29 2 *", utc(2023, 3, 1, 0, 0), []time.Time{utc(2024, 2, 29, 0, 0), utc(2028, 2, 29, 0, 0)}},
+		{"february 30", "0 0 30 2 *", utc(2023, 1, 1, 0, 0), []time.Time{{}}},
+		{"every", "@every 90m", utc(2023, 9, 5, 12, 0), []time.Time{utc(2023, 9, 5, 13, 30), utc(2023, 9, 5, 15, 0)}},
+		{"skipped hour", "30 2 * * *", time.Date(2023, 3, 12, 0, 0, 0, 0, ny), []time.Time{
+			time.Date(2023, 3, 12, 3, 0, 0, 0, ny), time.Date(2023, 3, 13, 2, 30, 0, 0, ny),
+		}},
+		{"repeated hour", "30 1 * * *", time.Date(2023, 11, 5, 0, 0, 0, 0, ny), []time.Time{edt(5, 1, 30), est(6, 1, 30)}},
+		{"repeated hour every hour", "30 * * * *", time.Date(2023, 11, 5, 0, 45, 0, 0, ny), []time.Time{edt(5, 1, 30), est(5, 1, 30), est(5, 2, 30)}},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		at := test.from
+		for _, want := range test.want {
+			at = s.Next(at)
+			if !at.Equal(want) {
+				t.Errorf("%s: Next = %v, want %v", test.name, at, want)
+				break
+			}
+		}
+	}
+}
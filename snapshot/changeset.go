@@ -0,0 +1,218 @@
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"libgen/sqldump"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChangesetExt is the extension of the changeset turning the previous
+// snapshot into a dump.
+const ChangesetExt = ".changes.jsonl.gz"
+
+// The operations of a changeset entry.
+const (
+	Added   = "added"
+	Changed = "changed"
+	Removed = "removed"
+)
+
+// Entry is a line of a changeset. The first line names the snapshot and its
+// base, a table line with the columns and key of the table precedes its
+// changes. Added and changed records carry every value in column order,
+// removed records the values of their key.
+type Entry struct {
+	Snapshot string      `json:"snapshot,omitempty"`
+	Base     string      `json:"base,omitempty"`
+	Created  string      `json:"created,omitempty"`
+	Table    string      `json:"table,omitempty"`
+	Columns  []ColumnDef `json:"columns,omitempty"`
+	Key      []string    `json:"key,omitempty"`
+	Op       string      `json:"op,omitempty"`
+	Values   []Value     `json:"values,omitempty"`
+}
+
+// ColumnDef is a column of a table with its MySQL type.
+type ColumnDef struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Value is a value of a changeset, NULL is written as null and text that is
+// not valid UTF-8 as {"base64": "..."}.
+type Value struct {
+	Null bool
+	Text string
+}
+
+func valueOf(v sqldump.Value) Value {
+	return Value{Null: v.IsNull(), Text: v.Text}
+}
+
+// SQL returns the value as read from a dump.
+func (v Value) SQL() sqldump.Value {
+	if v.Null {
+		return sqldump.Value{Kind: sqldump.Null}
+	}
+	return sqldump.Value{Kind: sqldump.String, Text: v.Text}
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Null {
+		return []byte("null"), nil
+	}
+	if !utf8.ValidString(v.Text) {
+		return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString([]byte(v.Text))})
+	}
+	return json.Marshal(v.Text)
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*v = Value{Null: true}
+		return nil
+	}
+	if len(b) > 0 && b[0] == '{' {
+		encoded := struct {
+			Base64 string `json:"base64"`
+		}{}
+		if err := json.Unmarshal(b, &encoded); err != nil {
+			return err
+		}
+		text, err := base64.StdEncoding.DecodeString(encoded.Base64)
+		*v = Value{Text: string(text)}
+		return err
+	}
+	*v = Value{}
+	return json.Unmarshal(b, &v.Text)
+}
+
+// ChangesetPath returns the path of the changeset of a dump in dir.
+func ChangesetPath(dir, snapshot string) string {
+	return filepath.Join(dir, snapshot+ChangesetExt)
+}
+
+// ErrBadChangeset is returned for files that are not changesets.
+var ErrBadChangeset = errors.New("not a changeset")
+
+// TableDef is a table of a changeset, Key lists the columns its records are
+// keyed by.
+type TableDef struct {
+	Name    string
+	Columns []ColumnDef
+	Key     []string
+	// KeyIndex holds the position of every key column in Columns.
+	KeyIndex []int
+}
+
+// Change is a record added, changed or removed. Values holds every value of
+// added and changed records and the key of removed ones.
+type Change struct {
+	Op     string
+	Table  *TableDef
+	Values []Value
+}
+
+// Changeset reads a changeset written by a Differ.
+type Changeset struct {
+	// Snapshot is the dump the changeset turns Base into.
+	Snapshot string
+	Base     string
+	Created  string
+	file     *os.File
+	gz       *gzip.Reader
+	dec      *json.Decoder
+	tables   map[string]*TableDef
+}
+
+// OpenChangeset opens a changeset and reads its header.
+func OpenChangeset(path string) (*Changeset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, ErrBadChangeset)
+	}
+	c := &Changeset{file: file, gz: gz, dec: json.NewDecoder(bufio.NewReaderSize(gz, 1024*1024)), tables: map[string]*TableDef{}}
+	header := Entry{}
+	if err = c.dec.Decode(&header); err != nil || len(header.Snapshot) == 0 || len(header.Base) == 0 {
+		c.Close()
+		return nil, fmt.Errorf("%s: %w", path, ErrBadChangeset)
+	}
+	c.Snapshot, c.Base, c.Created = header.Snapshot, header.Base, header.Created
+	return c, nil
+}
+
+// Next returns the next change, io.EOF once every change is read.
+func (c *Changeset) Next() (*Change, error) {
+	for {
+		entry := Entry{}
+		if err := c.dec.Decode(&entry); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("%w: %v", ErrBadChangeset, err)
+		}
+		if len(entry.Op) == 0 {
+			if err := c.define(entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		table, ok := c.tables[strings.ToLower(entry.Table)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s changes before its columns", ErrBadChangeset, entry.Table)
+		}
+		want := len(table.Columns)
+		if entry.Op == Removed {
+			want = len(table.Key)
+		} else if entry.Op != Added && entry.Op != Changed {
+			return nil, fmt.Errorf("%w: unknown operation %q", ErrBadChangeset, entry.Op)
+		}
+		if len(entry.Values) != want {
+			return nil, fmt.Errorf("%w: %s %s record has %d values instead of %d", ErrBadChangeset, entry.Op, table.Name, len(entry.Values), want)
+		}
+		return &Change{Op: entry.Op, Table: table, Values: entry.Values}, nil
+	}
+}
+
+// define reads a table line, tables whose records were only removed carry
+// their key columns alone.
+func (c *Changeset) define(entry Entry) error {
+	if len(entry.Table) == 0 || len(entry.Key) == 0 {
+		return fmt.Errorf("%w: unexpected line without an operation", ErrBadChangeset)
+	}
+	table := &TableDef{Name: entry.Table, Columns: entry.Columns, Key: entry.Key}
+	for _, key := range entry.Key {
+		index := -1
+		for i, column := range entry.Columns {
+			if strings.EqualFold(column.Name, key) {
+				index = i
+				break
+			}
+		}
+		if index < 0 && len(entry.Columns) > 0 {
+			return fmt.Errorf("%w: %s has no key column %s", ErrBadChangeset, entry.Table, key)
+		}
+		table.KeyIndex = append(table.KeyIndex, index)
+	}
+	c.tables[strings.ToLower(entry.Table)] = table
+	return nil
+}
+
+func (c *Changeset) Close() error {
+	c.gz.Close()
+	return c.file.Close()
+}
@@ -0,0 +1,394 @@
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"libgen/sqldump"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TableChanges counts the changes of a table against the base snapshot.
+type TableChanges struct {
+	Table   string
+	Rows    int64
+	Added   int64
+	Changed int64
+	Removed int64
+}
+
+type diffTable struct {
+	schema *sqldump.Table
+	index  map[string]int
+	key    []int
+	// mapping maps the values of the last INSERT column list to columns
+	mapping     []int
+	lastColumns *string
+	old         *TableIndex
+	records     *TableIndex
+	announced   bool
+	rows        int64
+	changes     TableChanges
+}
+
+// Differ indexes the records of a dump and, when a base snapshot is given,
+// writes the records added, changed and removed since the base to a
+// changeset. Records are keyed by the primary key of their table, else by
+// their ID or MD5 column.
+type Differ struct {
+	dir      string
+	snapshot string
+	base     *Index
+	tables   map[string]*diffTable
+	order    []string
+	file     *os.File
+	gz       *gzip.Writer
+	w        *bufio.Writer
+	enc      *json.Encoder
+	buf      []sqldump.Value
+	key      []byte
+}
+
+// NewDiffer writes the index of snapshot, and its changeset against base
+// unless base is nil, to dir.
+func NewDiffer(dir, snapshot string, base *Index) (*Differ, error) {
+	d := &Differ{dir: dir, snapshot: snapshot, base: base, tables: map[string]*diffTable{}}
+	if base == nil {
+		return d, nil
+	}
+	file, err := os.Create(ChangesetPath(dir, snapshot) + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	d.file = file
+	d.gz = gzip.NewWriter(file)
+	d.w = bufio.NewWriterSize(d.gz, 1024*1024)
+	d.enc = json.NewEncoder(d.w)
+	d.enc.SetEscapeHTML(false)
+	if err = d.enc.Encode(Entry{Snapshot: snapshot, Base: base.Snapshot, Created: time.Now().UTC().Format(time.RFC3339)}); err != nil {
+		d.discard()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Differ) Import(name string, r io.Reader) error {
+	reader := sqldump.NewReader(r)
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t, err := d.table(row)
+		if err != nil {
+			return err
+		}
+		values, err := t.values(row, d.buf)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", reader.Line(), err)
+		}
+		d.buf = values
+		d.key = d.key[:0]
+		for _, index := range t.key {
+			d.key = appendKey(d.key, values[index])
+		}
+		sum := recordSum(values)
+		t.records.add(d.key, sum)
+		t.rows++
+		if d.base == nil {
+			continue
+		}
+		op := Added
+		if i := t.old.find(d.key); i >= 0 {
+			t.old.seen[i] = true
+			if t.old.sums[i] == sum {
+				continue
+			}
+			op = Changed
+		}
+		if err = d.write(t, op, values); err != nil {
+			return err
+		}
+	}
+}
+
+// Close writes the removed records and saves the index and the changeset,
+// after a failure both are dropped.
+func (d *Differ) Close(err error) error {
+	if err != nil {
+		d.discard()
+		return err
+	}
+	if d.base != nil {
+		if err = d.removed(); err != nil {
+			d.discard()
+			return err
+		}
+	}
+	index := &Index{Snapshot: d.snapshot, Tables: map[string]*TableIndex{}}
+	for key, t := range d.tables {
+		index.Tables[key] = t.records.sorted()
+	}
+	if err = index.Save(IndexPath(d.dir, d.snapshot)); err != nil {
+		d.discard()
+		return err
+	}
+	if d.base == nil {
+		return nil
+	}
+	err = d.w.Flush()
+	if err == nil {
+		err = d.gz.Close()
+	}
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(d.file.Name())
+		return err
+	}
+	return os.Rename(d.file.Name(), ChangesetPath(d.dir, d.snapshot))
+}
+
+// Changes returns the records and changes of every table, tables only found
+// in the base count as removed.
+func (d *Differ) Changes() []TableChanges {
+	res := []TableChanges{}
+	for _, key := range d.order {
+		t := d.tables[key]
+		t.changes.Rows = t.rows
+		res = append(res, t.changes)
+	}
+	if d.base == nil {
+		return res
+	}
+	for _, key := range sortedKeys(d.base.Tables) {
+		if _, ok := d.tables[key]; !ok {
+			t := d.base.Tables[key]
+			res = append(res, TableChanges{Table: t.Name, Removed: int64(t.Len())})
+		}
+	}
+	return res
+}
+
+func (d *Differ) discard() {
+	if d.file != nil {
+		d.file.Close()
+		os.Remove(d.file.Name())
+	}
+}
+
+// table sets up the key and the base records of a table on its first row.
+func (d *Differ) table(row *sqldump.Row) (*diffTable, error) {
+	name := strings.ToLower(row.Table.Name)
+	if t, ok := d.tables[name]; ok {
+		return t, nil
+	}
+	schema, err := row.Schema()
+	if err != nil {
+		return nil, err
+	}
+	t := &diffTable{schema: schema, index: map[string]int{}}
+	for i, c := range schema.Columns {
+		t.index[strings.ToLower(c.Name)] = i
+	}
+	t.key = t.keyColumns()
+	names := make([]string, 0, len(t.key))
+	for _, i := range t.key {
+		names = append(names, schema.Columns[i].Name)
+	}
+	t.records = newTableIndex(schema.Name, names)
+	t.changes.Table = schema.Name
+	if d.base != nil {
+		t.old = d.base.Tables[name]
+		if t.old == nil {
+			t.old = newTableIndex(schema.Name, names)
+		} else if strings.Join(t.old.Key, ",") != strings.Join(names, ",") {
+			return nil, fmt.Errorf("%s is keyed by %s in %s but by %s in %s", schema.Name, strings.Join(t.old.Key, ","), d.base.Snapshot, strings.Join(names, ","), d.snapshot)
+		}
+		t.old.seen = make([]bool, t.old.Len())
+	}
+	d.tables[name] = t
+	d.order = append(d.order, name)
+	return t, nil
+}
+
+// keyColumns returns the primary key, else the ID or MD5 column, else every
+// column of the table.
+func (t *diffTable) keyColumns() []int {
+	key := []int{}
+	for _, c := range t.schema.PrimaryKey {
+		if i, ok := t.index[strings.ToLower(c)]; ok {
+			key = append(key, i)
+		}
+	}
+	if len(key) > 0 {
+		return key
+	}
+	for _, c := range []string{"id", "md5"} {
+		if i, ok := t.index[c]; ok {
+			return []int{i}
+		}
+	}
+	for i := range t.schema.Columns {
+		key = append(key, i)
+	}
+	return key
+}
+
+// values returns the row in table column order, reusing buf.
+func (t *diffTable) values(row *sqldump.Row, buf []sqldump.Value) ([]sqldump.Value, error) {
+	width := len(t.schema.Columns)
+	if cap(buf) < width {
+		buf = make([]sqldump.Value, width)
+	}
+	buf = buf[:width]
+	if len(row.Columns) == 0 {
+		if len(row.Values) != width {
+			return nil, fmt.Errorf("%s has %d columns, got %d values", t.schema.Name, width, len(row.Values))
+		}
+		copy(buf, row.Values)
+		return buf, nil
+	}
+	// the reader shares the column list between the rows of an INSERT
+	if &row.Columns[0] != t.lastColumns {
+		t.mapping = make([]int, len(row.Columns))
+		for i, name := range row.Columns {
+			index, ok := t.index[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("%s has no column %s", t.schema.Name, name)
+			}
+			t.mapping[i] = index
+		}
+		t.lastColumns = &row.Columns[0]
+	}
+	for i := range buf {
+		buf[i] = sqldump.Value{}
+	}
+	for i, v := range row.Values {
+		buf[t.mapping[i]] = v
+	}
+	return buf, nil
+}
+
+// recordSum hashes the values of a record, their kind is part of the hash
+// so that NULL differs from an empty string.
+func recordSum(values []sqldump.Value) uint64 {
+	h := fnv.New64a()
+	for _, v := range values {
+		h.Write([]byte{byte(v.Kind), 0})
+		h.Write([]byte(v.Text))
+		h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
+
+func (d *Differ) write(t *diffTable, op string, values []sqldump.Value) error {
+	if !t.announced {
+		entry := Entry{Table: t.schema.Name, Key: t.records.Key}
+		for _, c := range t.schema.Columns {
+			entry.Columns = append(entry.Columns, ColumnDef{Name: c.Name, Type: c.Type})
+		}
+		if err := d.enc.Encode(entry); err != nil {
+			return err
+		}
+		t.announced = true
+	}
+	entry := Entry{Op: op, Table: t.schema.Name, Values: make([]Value, len(values))}
+	for i, v := range values {
+		entry.Values[i] = valueOf(v)
+	}
+	switch op {
+	case Added:
+		t.changes.Added++
+	case Changed:
+		t.changes.Changed++
+	}
+	return d.enc.Encode(entry)
+}
+
+// removed writes the base records that were not found in the dump.
+func (d *Differ) removed() error {
+	for _, key := range sortedKeys(d.base.Tables) {
+		old := d.base.Tables[key]
+		t, ok := d.tables[key]
+		if ok {
+			old = t.old
+		}
+		announced := ok && t.announced
+		for i := 0; i < old.Len(); i++ {
+			if ok && old.seen[i] {
+				continue
+			}
+			if !announced {
+				if err := d.enc.Encode(Entry{Table: old.Name, Key: old.Key}); err != nil {
+					return err
+				}
+				announced = true
+			}
+			values, err := splitKey(old.key(i))
+			if err != nil {
+				return fmt.Errorf("%s: %w", old.Name, err)
+			}
+			if err := d.enc.Encode(Entry{Op: Removed, Table: old.Name, Values: values}); err != nil {
+				return err
+			}
+			if ok {
+				t.changes.Removed++
+			}
+		}
+	}
+	return nil
+}
+
+// appendKey appends a value of a key column to key: 0 for NULL, else 1 and
+// the length of the text before the text, so that no value can pass for
+// another or for several.
+func appendKey(key []byte, v sqldump.Value) []byte {
+	if v.IsNull() {
+		return append(key, 0)
+	}
+	key = append(key, 1)
+	key = binary.AppendUvarint(key, uint64(len(v.Text)))
+	return append(key, v.Text...)
+}
+
+// splitKey returns the values of a key written by appendKey.
+func splitKey(key []byte) ([]Value, error) {
+	values := []Value{}
+	for len(key) > 0 {
+		null := key[0] == 0
+		key = key[1:]
+		if null {
+			values = append(values, Value{Null: true})
+			continue
+		}
+		size, n := binary.Uvarint(key)
+		if n <= 0 || size > uint64(len(key)-n) {
+			return nil, ErrBadIndex
+		}
+		end := n + int(size)
+		values = append(values, Value{Text: string(key[n:end])})
+		key = key[end:]
+	}
+	return values, nil
+}
+
+func sortedKeys(tables map[string]*TableIndex) []string {
+	keys := make([]string, 0, len(tables))
+	for key := range tables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+package snapshot
+
+import (
+	"io"
+	"libgen/sqldump"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	tests := [][]sqldump.Value{
+		{{Kind: sqldump.String, Text: "1"}},
+		{{Kind: sqldump.String, Text: "a\x00b"}, {Kind: sqldump.String, Text: "c"}},
+		{{Kind: sqldump.String, Text: "a"}, {Kind: sqldump.String, Text: "b\x00c"}},
+		{{Kind: sqldump.Null}, {Kind: sqldump.String}},
+		{{Kind: sqldump.String}, {Kind: sqldump.Null}},
+		{{Kind: sqldump.String, Text: strings.Repeat("x", 300)}},
+	}
+	seen := map[string]bool{}
+	for _, values := range tests {
+		key := []byte{}
+		want := []Value{}
+		for _, v := range values {
+			key = appendKey(key, v)
+			want = append(want, valueOf(v))
+		}
+		if seen[string(key)] {
+			t.Errorf("%v has the key of another record", values)
+		}
+		seen[string(key)] = true
+		got, err := splitKey(key)
+		if err != nil {
+			t.Errorf("splitKey(%q): %v", key, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("splitKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+	if _, err := splitKey([]byte{1, 5, 'a'}); err == nil {
+		t.Error("splitKey accepted a truncated key")
+	}
+}
+
+const baseDump = "CREATE TABLE `k` (`a` varchar(10), `b` varchar(10), `v` int, PRIMARY KEY (`a`, `b`));\n" +
+	"INSERT INTO `k` VALUES ('x\\0y','z',1),('x','y\\0z',2),('same','',3),('keep','k',4);\n" +
+	"CREATE TABLE `n` (`title` varchar(10), `note` varchar(10));\n" +
+	"INSERT INTO `n` VALUES ('a',NULL),('a','');\n"
+
+const nextDump = "CREATE TABLE `k` (`a` varchar(10), `b` varchar(10), `v` int, PRIMARY KEY (`a`, `b`));\n" +
+	"INSERT INTO `k` VALUES ('keep','k',5),('x','y\\0z',2),('new','',6);\n" +
+	"CREATE TABLE `n` (`title` varchar(10), `note` varchar(10));\n" +
+	"INSERT INTO `n` VALUES ('a','');\n"
+
+func diff(t *testing.T, dir, snapshot, dump string, base *Index) *Differ {
+	t.Helper()
+	d, err := NewDiffer(dir, snapshot, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Import(snapshot+"/dump.sql", strings.NewReader(dump))
+	if err = d.Close(err); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestDiff indexes a dump, diffs the next one against it and reads the
+// changeset back, keys holding NUL bytes or NULL must not be confused.
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	diff(t, dir, "libgen_2023-09-05", baseDump, nil)
+	base, err := LoadIndex(IndexPath(dir, "libgen_2023-09-05"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Snapshot != "libgen_2023-09-05" || base.Tables["k"].Len() != 4 || base.Tables["n"].Len() != 2 {
+		t.Fatalf("index of %s has %d and %d records, want 4 and 2", base.Snapshot, base.Tables["k"].Len(), base.Tables["n"].Len())
+	}
+	if key := base.Tables["n"].Key; !reflect.DeepEqual(key, []string{"title", "note"}) {
+		t.Errorf("n is keyed by %v, want every column", key)
+	}
+
+	d := diff(t, dir, "libgen_2023-09-12", nextDump, base)
+	changes := d.Changes()
+	want := []TableChanges{
+		{Table: "k", Rows: 3, Added: 1, Changed: 1, Removed: 2},
+		{Table: "n", Rows: 1, Removed: 1},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Changes = %+v, want %+v", changes, want)
+	}
+
+	cs, err := OpenChangeset(ChangesetPath(dir, "libgen_2023-09-12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	if cs.Base != "libgen_2023-09-05" || cs.Snapshot != "libgen_2023-09-12" {
+		t.Errorf("changeset turns %s into %s", cs.Base, cs.Snapshot)
+	}
+	got := []Change{}
+	for {
+		change, err := cs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, *change)
+	}
+	text := func(s string) Value { return Value{Text: s} }
+	// removed records come in the order of their keys
+	expected := []struct {
+		op, table string
+		values    []Value
+	}{
+		{Changed, "k", []Value{text("keep"), text("k"), text("5")}},
+		{Added, "k", []Value{text("new"), text(""), text("6")}},
+		{Removed, "k", []Value{text("x\x00y"), text("z")}},
+		{Removed, "k", []Value{text("same"), text("")}},
+		{Removed, "n", []Value{text("a"), {Null: true}}},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("%d changes, want %d: %+v", len(got), len(expected), got)
+	}
+	for i, e := range expected {
+		c := got[i]
+		if c.Op != e.op || c.Table.Name != e.table || !reflect.DeepEqual(c.Values, e.values) {
+			t.Errorf("change %d = %s %s %v, want %s %s %v", i, c.Op, c.Table.Name, c.Values, e.op, e.table, e.values)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const indexMagic = "LGIDX2\n"
+
+// IndexExt is the extension of the record index of a dump.
+const IndexExt = ".index"
+
+// ErrBadIndex is returned for files that are not record indexes.
+var ErrBadIndex = errors.New("not a record index")
+
+// Index holds a hash of every record of a snapshot by table and key, it is
+// all that is kept of a dump to diff the next one against.
+type Index struct {
+	Snapshot string
+	Tables   map[string]*TableIndex
+}
+
+// TableIndex holds the records of a table sorted by key, the keys are packed
+// into one buffer to keep millions of records small. A key holds the values
+// of the key columns written by appendKey.
+type TableIndex struct {
+	Name string
+	// Key lists the columns the records are keyed by.
+	Key     []string
+	keys    []byte
+	offsets []uint64
+	sums    []uint64
+	seen    []bool
+}
+
+func newTableIndex(name string, key []string) *TableIndex {
+	return &TableIndex{Name: name, Key: key, offsets: []uint64{0}}
+}
+
+func (t *TableIndex) Len() int {
+	return len(t.sums)
+}
+
+func (t *TableIndex) key(i int) []byte {
+	return t.keys[t.offsets[i]:t.offsets[i+1]]
+}
+
+func (t *TableIndex) add(key []byte, sum uint64) {
+	t.keys = append(t.keys, key...)
+	t.offsets = append(t.offsets, uint64(len(t.keys)))
+	t.sums = append(t.sums, sum)
+}
+
+// find returns the position of key, or -1.
+func (t *TableIndex) find(key []byte) int {
+	i := sort.Search(t.Len(), func(i int) bool {
+		return bytes.Compare(t.key(i), key) >= 0
+	})
+	if i < t.Len() && bytes.Equal(t.key(i), key) {
+		return i
+	}
+	return -1
+}
+
+// sorted returns the records ordered by key, a later record replaces an
+// earlier one with the same key.
+func (t *TableIndex) sorted() *TableIndex {
+	order := make([]int, t.Len())
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return bytes.Compare(t.key(order[a]), t.key(order[b])) < 0
+	})
+	res := newTableIndex(t.Name, t.Key)
+	res.keys = make([]byte, 0, len(t.keys))
+	for n, i := range order {
+		if n+1 < len(order) && bytes.Equal(t.key(i), t.key(order[n+1])) {
+			continue
+		}
+		res.add(t.key(i), t.sums[i])
+	}
+	return res
+}
+
+// IndexPath returns the path of the record index of a dump in dir.
+func IndexPath(dir, snapshot string) string {
+	return filepath.Join(dir, snapshot+IndexExt)
+}
+
+// LoadIndex reads a record index written by Save.
+func LoadIndex(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrBadIndex)
+	}
+	r := bufio.NewReaderSize(gz, 1024*1024)
+	magic := make([]byte, len(indexMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != indexMagic {
+		return nil, fmt.Errorf("%s: %w", path, ErrBadIndex)
+	}
+	index := &Index{Tables: map[string]*TableIndex{}}
+	if index.Snapshot, err = readString(r); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(name) == 0 {
+			return index, nil
+		}
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		t := newTableIndex(name, strings.Split(key, "\x00"))
+		t.sums = make([]uint64, 0, count)
+		t.offsets = make([]uint64, 1, count+1)
+		sum := make([]byte, 8)
+		for i := uint64(0); i < count; i++ {
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			start := len(t.keys)
+			t.keys = append(t.keys, make([]byte, size)...)
+			if _, err = io.ReadFull(r, t.keys[start:]); err != nil {
+				return nil, err
+			}
+			if _, err = io.ReadFull(r, sum); err != nil {
+				return nil, err
+			}
+			t.offsets = append(t.offsets, uint64(len(t.keys)))
+			t.sums = append(t.sums, binary.LittleEndian.Uint64(sum))
+		}
+		index.Tables[strings.ToLower(name)] = t
+	}
+}
+
+// Save writes the index to path through a temporary file.
+func (index *Index) Save(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(file)
+	w := bufio.NewWriterSize(gz, 1024*1024)
+	w.WriteString(indexMagic)
+	writeString(w, index.Snapshot)
+	names := make([]string, 0, len(index.Tables))
+	for name := range index.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := index.Tables[name]
+		writeString(w, t.Name)
+		writeString(w, strings.Join(t.Key, "\x00"))
+		w.Write(binary.AppendUvarint(nil, uint64(t.Len())))
+		buf := []byte{}
+		for i := 0; i < t.Len(); i++ {
+			key := t.key(i)
+			buf = binary.AppendUvarint(buf[:0], uint64(len(key)))
+			buf = append(buf, key...)
+			buf = binary.LittleEndian.AppendUint64(buf, t.sums[i])
+			if _, err = w.Write(buf); err != nil {
+				break
+			}
+		}
+	}
+	writeString(w, "")
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	return string(b), err
+}
+
+func writeString(w *bufio.Writer, s string) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
@@ -0,0 +1,301 @@
+package sqldump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lexer reads the tokens of a MySQL script byte by byte, the dumps are far
+// too large to be held in memory.
+type lexer struct {
+	r    *bufio.Reader
+	line int
+	buf  []byte
+}
+
+func newLexer(r io.Reader) *lexer {
+	return &lexer{r: bufio.NewReaderSize(r, 1024*1024), line: 1}
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) next() (byte, error) {
+	c, err := l.r.ReadByte()
+	if c == '\n' {
+		l.line++
+	}
+	return c, err
+}
+
+// need returns the next byte, the end of the input is an error as the
+// statement being read is cut short.
+func (l *lexer) need() (byte, error) {
+	c, err := l.next()
+	if err == io.EOF {
+		return 0, l.errorf("unexpected end of input")
+	}
+	return c, err
+}
+
+// peek returns the next byte without consuming it, 0 at the end of the input.
+func (l *lexer) peek() byte {
+	b, _ := l.r.Peek(1)
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0]
+}
+
+// hasPrefix reports whether the input continues with prefix.
+func (l *lexer) hasPrefix(prefix string) bool {
+	b, _ := l.r.Peek(len(prefix))
+	return string(b) == prefix
+}
+
+func (l *lexer) skip(n int) {
+	for i := 0; i < n; i++ {
+		l.next()
+	}
+}
+
+// skipSpace skips whitespace and comments, conditional comments like
+// /*!40101 SET NAMES utf8 */ are skipped as well.
+func (l *lexer) skipSpace() error {
+	for {
+		c := l.peek()
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.next()
+		case c == '#':
+			if err := l.skipLine(); err != nil {
+				return err
+			}
+		case c == '-' && l.lineComment():
+			if err := l.skipLine(); err != nil {
+				return err
+			}
+		case c == '/' && l.hasPrefix("/*"):
+			l.skip(2)
+			if err := l.skipUntil("*/"); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// lineComment reports whether the input starts with "-- ", MySQL needs the
+// whitespace after the dashes.
+func (l *lexer) lineComment() bool {
+	b, _ := l.r.Peek(3)
+	if len(b) < 2 || b[0] != '-' || b[1] != '-' {
+		return false
+	}
+	return len(b) == 2 || b[2] == ' ' || b[2] == '\t' || b[2] == '\n' || b[2] == '\r'
+}
+
+func (l *lexer) skipLine() error {
+	for {
+		c, err := l.next()
+		if err != nil || c == '\n' {
+			return err
+		}
+	}
+}
+
+func (l *lexer) skipUntil(end string) error {
+	for {
+		if l.hasPrefix(end) {
+			l.skip(len(end))
+			return nil
+		}
+		if _, err := l.next(); err != nil {
+			if err == io.EOF {
+				return l.errorf("unterminated comment")
+			}
+			return err
+		}
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
+
+// word reads a keyword, a bare identifier or a number.
+func (l *lexer) word() string {
+	l.buf = l.buf[:0]
+	for c := l.peek(); isWordByte(c) || (len(l.buf) > 0 && c == '.' && isNumber(l.buf)); c = l.peek() {
+		l.next()
+		l.buf = append(l.buf, c)
+	}
+	return string(l.buf)
+}
+
+func isNumber(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// keyword reads a word and upper cases it.
+func (l *lexer) keyword() (string, error) {
+	if err := l.skipSpace(); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(l.word()), nil
+}
+
+// expect consumes c after any whitespace.
+func (l *lexer) expect(c byte) error {
+	if err := l.skipSpace(); err != nil {
+		return err
+	}
+	got, err := l.next()
+	if err != nil {
+		if err == io.EOF {
+			return l.errorf("expected %q, got end of input", c)
+		}
+		return err
+	}
+	if got != c {
+		return l.errorf("expected %q, got %q", c, got)
+	}
+	return nil
+}
+
+// ident reads a quoted or bare identifier, of a qualified name like
+// `db`.`table` the last part is returned.
+func (l *lexer) ident() (string, error) {
+	if err := l.skipSpace(); err != nil {
+		return "", err
+	}
+	name := ""
+	c := l.peek()
+	switch {
+	case c == '`' || c == '"':
+		l.next()
+		s, err := l.quoted(c, false)
+		if err != nil {
+			return "", err
+		}
+		name = s
+	case isWordByte(c):
+		name = l.word()
+	default:
+		return "", l.errorf("expected an identifier, got %q", c)
+	}
+	if l.peek() == '.' {
+		l.next()
+		return l.ident()
+	}
+	return name, nil
+}
+
+// quoted reads a quoted string after its opening quote. Doubled quotes stand
+// for one quote, backslash escapes are only read in string literals.
+func (l *lexer) quoted(quote byte, escapes bool) (string, error) {
+	l.buf = l.buf[:0]
+	for {
+		// copy the run up to the next quote or backslash at once
+		if n := l.r.Buffered(); n > 0 {
+			b, _ := l.r.Peek(n)
+			end := 0
+			for end < len(b) && b[end] != quote && b[end] != '\\' {
+				if b[end] == '\n' {
+					l.line++
+				}
+				end++
+			}
+			l.buf = append(l.buf, b[:end]...)
+			l.r.Discard(end)
+		}
+		c, err := l.next()
+		if err != nil {
+			if err == io.EOF {
+				return "", l.errorf("unterminated string")
+			}
+			return "", err
+		}
+		switch {
+		case c == quote:
+			if l.peek() != quote {
+				return string(l.buf), nil
+			}
+			l.next()
+			l.buf = append(l.buf, quote)
+		case c == '\\' && escapes:
+			e, err := l.next()
+			if err != nil {
+				return "", l.errorf("unterminated string")
+			}
+			l.buf = append(l.buf, unescape(e)...)
+		default:
+			l.buf = append(l.buf, c)
+		}
+	}
+}
+
+// unescape returns the bytes a backslash escape stands for, \% and \_ keep
+// their backslash as in MySQL.
+func unescape(c byte) []byte {
+	switch c {
+	case '0':
+		return []byte{0}
+	case 'b':
+		return []byte{'\b'}
+	case 'n':
+		return []byte{'\n'}
+	case 'r':
+		return []byte{'\r'}
+	case 't':
+		return []byte{'\t'}
+	case 'Z':
+		return []byte{26}
+	case '%', '_':
+		return []byte{'\\', c}
+	default:
+		return []byte{c}
+	}
+}
+
+// balanced reads the text up to the parenthesis closing the one already
+// consumed, strings and nested parentheses are skipped over.
+func (l *lexer) balanced() (string, error) {
+	text := []byte{}
+	depth := 1
+	for {
+		c, err := l.next()
+		if err != nil {
+			if err == io.EOF {
+				return "", l.errorf("unbalanced parentheses")
+			}
+			return "", err
+		}
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(text), nil
+			}
+		case '\'', '"', '`':
+			s, err := l.quoted(c, c != '`')
+			if err != nil {
+				return "", err
+			}
+			text = append(text, c)
+			text = append(text, strings.ReplaceAll(s, string(c), string([]byte{c, c}))...)
+		}
+		text = append(text, c)
+	}
+}
@@ -0,0 +1,539 @@
+package sqldump
+
+import (
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Column is a column of a CREATE TABLE statement, Type is the column type as
+// written in the dump, e.g. varchar(100).
+type Column struct {
+	Name string
+	Type string
+}
+
+type Table struct {
+	Name    string
+	Columns []Column
+	// PrimaryKey lists the columns of the primary key, if any.
+	PrimaryKey []string
+}
+
+// ColumnNames returns the names of the columns in table order.
+func (t *Table) ColumnNames() []string {
+	names := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// Row is a row of an INSERT statement, Columns holds the column name of every
+// value.
+type Row struct {
+	Table   *Table
+	Columns []string
+	Values  []Value
+}
+
+// Get returns the value of column, names are compared case-insensitively.
+func (r *Row) Get(column string) (Value, bool) {
+	for i, name := range r.Columns {
+		if i < len(r.Values) && strings.EqualFold(name, column) {
+			return r.Values[i], true
+		}
+	}
+	return Value{}, false
+}
+
+// Reader reads the rows of a mysqldump script one at a time. CREATE TABLE
+// statements are recorded on the way so rows carry their column names,
+// every other statement is skipped.
+type Reader struct {
+	lex       *lexer
+	tables    map[string]*Table
+	delimiter string
+	// insert is the table of the INSERT statement being read
+	insert  *Table
+	columns []string
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		lex:       newLexer(r),
+		tables:    map[string]*Table{},
+		delimiter: ";",
+	}
+}
+
+// Table returns a table whose CREATE TABLE statement was read.
+func (r *Reader) Table(name string) (*Table, bool) {
+	t, ok := r.tables[strings.ToLower(name)]
+	return t, ok
+}
+
+// Tables returns the tables read so far.
+func (r *Reader) Tables() []*Table {
+	res := make([]*Table, 0, len(r.tables))
+	for _, t := range r.tables {
+		res = append(res, t)
+	}
+	return res
+}
+
+// Line returns the line of the script the reader is at.
+func (r *Reader) Line() int {
+	return r.lex.line
+}
+
+// Next returns the next row, io.EOF is returned at the end of the script.
+func (r *Reader) Next() (*Row, error) {
+	for r.insert == nil {
+		if err := r.statement(); err != nil {
+			return nil, err
+		}
+	}
+	row, err := r.row()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.lex.skipSpace(); err != nil {
+		return nil, err
+	}
+	if r.lex.peek() == ',' {
+		r.lex.next()
+	} else {
+		r.insert = nil
+		if err = r.endStatement(); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// statement reads the start of the next statement, an INSERT is left with
+// its rows unread.
+func (r *Reader) statement() error {
+	lex := r.lex
+	if err := lex.skipSpace(); err != nil {
+		return err
+	}
+	if lex.peek() == 0 {
+		if _, err := lex.r.Peek(1); err != nil {
+			return err
+		}
+	}
+	if lex.hasPrefix(r.delimiter) {
+		lex.skip(len(r.delimiter))
+		return nil
+	}
+	keyword := strings.ToUpper(lex.word())
+	switch keyword {
+	case "INSERT", "REPLACE":
+		return r.insertStatement()
+	case "CREATE":
+		return r.createStatement()
+	case "DELIMITER":
+		if err := lex.skipSpace(); err != nil {
+			return err
+		}
+		delimiter := []byte{}
+		for c := lex.peek(); c != 0 && c != '\n' && c != '\r' && c != ' '; c = lex.peek() {
+			lex.next()
+			delimiter = append(delimiter, c)
+		}
+		if len(delimiter) == 0 {
+			return lex.errorf("empty delimiter")
+		}
+		r.delimiter = string(delimiter)
+		return nil
+	default:
+		return r.skipStatement()
+	}
+}
+
+// skipStatement skips to the end of the statement.
+func (r *Reader) skipStatement() error {
+	lex := r.lex
+	for {
+		if err := lex.skipSpace(); err != nil {
+			return err
+		}
+		if lex.hasPrefix(r.delimiter) {
+			lex.skip(len(r.delimiter))
+			return nil
+		}
+		c, err := lex.next()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case '\'', '"', '`':
+			if _, err = lex.quoted(c, c != '`'); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// endStatement consumes the delimiter ending an INSERT.
+func (r *Reader) endStatement() error {
+	if r.lex.hasPrefix(r.delimiter) {
+		r.lex.skip(len(r.delimiter))
+		return nil
+	}
+	if r.lex.peek() == 0 {
+		return nil
+	}
+	return r.lex.errorf("expected %q after the values", r.delimiter)
+}
+
+// insertStatement reads INSERT [IGNORE] INTO table [(columns)] VALUES.
+func (r *Reader) insertStatement() error {
+	lex := r.lex
+	for {
+		keyword, err := lex.keyword()
+		if err != nil {
+			return err
+		}
+		if keyword == "INTO" {
+			break
+		}
+		if keyword != "IGNORE" && keyword != "LOW_PRIORITY" && keyword != "DELAYED" && keyword != "HIGH_PRIORITY" {
+			return lex.errorf("unexpected %q in INSERT", keyword)
+		}
+	}
+	name, err := lex.ident()
+	if err != nil {
+		return err
+	}
+	table, ok := r.Table(name)
+	if !ok {
+		table = &Table{Name: name}
+		r.tables[strings.ToLower(name)] = table
+	}
+	columns := table.ColumnNames()
+	if err = lex.skipSpace(); err != nil {
+		return err
+	}
+	if lex.peek() == '(' {
+		lex.next()
+		columns = []string{}
+		for {
+			column, err := lex.ident()
+			if err != nil {
+				return err
+			}
+			columns = append(columns, column)
+			if err = lex.skipSpace(); err != nil {
+				return err
+			}
+			c, err := lex.need()
+			if err != nil {
+				return err
+			}
+			if c == ')' {
+				break
+			}
+			if c != ',' {
+				return lex.errorf("expected ',' in the column list of %s, got %q", name, c)
+			}
+		}
+	}
+	keyword, err := lex.keyword()
+	if err != nil {
+		return err
+	}
+	if keyword != "VALUES" && keyword != "VALUE" {
+		// INSERT ... SELECT and INSERT ... SET are not dump output
+		return r.skipStatement()
+	}
+	r.insert = table
+	r.columns = columns
+	return nil
+}
+
+// createStatement records the columns of CREATE TABLE, other CREATE
+// statements are skipped.
+func (r *Reader) createStatement() error {
+	lex := r.lex
+	keyword, err := lex.keyword()
+	if err != nil {
+		return err
+	}
+	for keyword == "TEMPORARY" || keyword == "OR" || keyword == "REPLACE" {
+		if keyword, err = lex.keyword(); err != nil {
+			return err
+		}
+	}
+	if keyword != "TABLE" {
+		return r.skipStatement()
+	}
+	if err = lex.skipSpace(); err != nil {
+		return err
+	}
+	if b, _ := lex.r.Peek(3); len(b) == 3 && strings.EqualFold(string(b[:2]), "IF") && !isWordByte(b[2]) {
+		for i := 0; i < 3; i++ {
+			if _, err = lex.keyword(); err != nil {
+				return err
+			}
+		}
+	}
+	name, err := lex.ident()
+	if err != nil {
+		return err
+	}
+	if err = lex.expect('('); err != nil {
+		return err
+	}
+	table := &Table{Name: name}
+	for {
+		if err = lex.skipSpace(); err != nil {
+			return err
+		}
+		quoted := lex.peek() == '`' || lex.peek() == '"'
+		first, err := lex.ident()
+		if err != nil {
+			return err
+		}
+		if !quoted && isConstraint(first) {
+			if strings.EqualFold(first, "PRIMARY") {
+				if table.PrimaryKey, err = r.keyColumns(); err != nil {
+					return err
+				}
+			}
+			first = ""
+		}
+		columnType := ""
+		if len(first) > 0 {
+			if columnType, err = lex.keyword(); err != nil {
+				return err
+			}
+			columnType = strings.ToLower(columnType)
+			if lex.peek() == '(' {
+				lex.next()
+				args, err := lex.balanced()
+				if err != nil {
+					return err
+				}
+				columnType += "(" + args + ")"
+			}
+			table.Columns = append(table.Columns, Column{Name: first, Type: columnType})
+		}
+		// skip the rest of the definition
+		end, err := r.skipDefinition()
+		if err != nil {
+			return err
+		}
+		if end == ')' {
+			break
+		}
+	}
+	r.tables[strings.ToLower(name)] = table
+	return r.skipStatement()
+}
+
+// keyColumns reads KEY [name] (columns) after PRIMARY, prefix lengths like
+// `Title`(100) are dropped.
+func (r *Reader) keyColumns() ([]string, error) {
+	lex := r.lex
+	if keyword, err := lex.keyword(); err != nil || keyword != "KEY" {
+		return nil, err
+	}
+	if err := lex.expect('('); err != nil {
+		return nil, err
+	}
+	columns := []string{}
+	for {
+		column, err := lex.ident()
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+		if err = lex.skipSpace(); err != nil {
+			return nil, err
+		}
+		c, err := lex.need()
+		if err != nil {
+			return nil, err
+		}
+		if c == '(' {
+			if _, err = lex.balanced(); err != nil {
+				return nil, err
+			}
+			if err = lex.skipSpace(); err != nil {
+				return nil, err
+			}
+			if c, err = lex.need(); err != nil {
+				return nil, err
+			}
+		}
+		if c == ')' {
+			return columns, nil
+		}
+		if c != ',' {
+			return nil, lex.errorf("expected ',' in the primary key, got %q", c)
+		}
+	}
+}
+
+func isConstraint(word string) bool {
+	switch strings.ToUpper(word) {
+	case "PRIMARY", "KEY", "INDEX", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK":
+		return true
+	}
+	return false
+}
+
+// skipDefinition skips to the comma after a column or key definition or to
+// the parenthesis closing the definitions and returns which one ended it.
+func (r *Reader) skipDefinition() (byte, error) {
+	lex := r.lex
+	for {
+		if err := lex.skipSpace(); err != nil {
+			return 0, err
+		}
+		c, err := lex.next()
+		if err != nil {
+			if err == io.EOF {
+				return 0, lex.errorf("unterminated CREATE TABLE")
+			}
+			return 0, err
+		}
+		switch c {
+		case ',', ')':
+			return c, nil
+		case '(':
+			if _, err = lex.balanced(); err != nil {
+				return 0, err
+			}
+		case '\'', '"', '`':
+			if _, err = lex.quoted(c, c != '`'); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// row reads a parenthesized list of values.
+func (r *Reader) row() (*Row, error) {
+	lex := r.lex
+	if err := lex.expect('('); err != nil {
+		return nil, err
+	}
+	row := Row{
+		Table:   r.insert,
+		Columns: r.columns,
+		Values:  make([]Value, 0, len(r.columns)),
+	}
+	for {
+		value, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		row.Values = append(row.Values, value)
+		if err = lex.skipSpace(); err != nil {
+			return nil, err
+		}
+		c, err := lex.need()
+		if err != nil {
+			return nil, err
+		}
+		if c == ')' {
+			break
+		}
+		if c != ',' {
+			return nil, lex.errorf("expected ',' between the values of %s, got %q", r.insert.Name, c)
+		}
+	}
+	if len(row.Columns) > 0 && len(row.Columns) != len(row.Values) {
+		return nil, lex.errorf("%s has %d columns but a row has %d values", r.insert.Name, len(row.Columns), len(row.Values))
+	}
+	return &row, nil
+}
+
+// value reads a literal: a string, a number, NULL, a hex or bit literal or a
+// string with a character set introducer like _binary '...'.
+func (r *Reader) value() (Value, error) {
+	lex := r.lex
+	if err := lex.skipSpace(); err != nil {
+		return Value{}, err
+	}
+	c := lex.peek()
+	switch {
+	case c == '\'' || c == '"':
+		lex.next()
+		s, err := lex.quoted(c, true)
+		return Value{Kind: String, Text: s}, err
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return r.number()
+	case isWordByte(c):
+		word := lex.word()
+		upper := strings.ToUpper(word)
+		switch {
+		case upper == "NULL":
+			return Value{Kind: Null}, nil
+		case (upper == "X" || upper == "B") && lex.peek() == '\'':
+			lex.next()
+			s, err := lex.quoted('\'', false)
+			if err != nil {
+				return Value{}, err
+			}
+			if upper == "B" {
+				return Value{Kind: Number, Text: bitsToNumber(s)}, nil
+			}
+			return decodeHex(lex, s)
+		case strings.HasPrefix(word, "_"):
+			// a character set introducer, the string follows
+			value, err := r.value()
+			if err == nil && value.Kind == String && upper == "_BINARY" {
+				value.Kind = Binary
+			}
+			return value, err
+		}
+		return Value{Kind: Word, Text: word}, nil
+	}
+	return Value{}, lex.errorf("unexpected %q in the values of %s", c, r.insert.Name)
+}
+
+func (r *Reader) number() (Value, error) {
+	lex := r.lex
+	text := []byte{}
+	if c := lex.peek(); c == '-' || c == '+' {
+		lex.next()
+		text = append(text, c)
+	}
+	if lex.hasPrefix("0x") || lex.hasPrefix("0X") {
+		lex.skip(2)
+		return decodeHex(lex, lex.word())
+	}
+	for c := lex.peek(); (c >= '0' && c <= '9') || c == '.' || c == 'e' || c == 'E' || ((c == '-' || c == '+') && len(text) > 0 && (text[len(text)-1] == 'e' || text[len(text)-1] == 'E')); c = lex.peek() {
+		lex.next()
+		text = append(text, c)
+	}
+	if len(text) == 0 {
+		return Value{}, lex.errorf("invalid number")
+	}
+	return Value{Kind: Number, Text: string(text)}, nil
+}
+
+func decodeHex(lex *lexer, digits string) (Value, error) {
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	data, err := hex.DecodeString(digits)
+	if err != nil {
+		return Value{}, lex.errorf("invalid hex literal: %v", err)
+	}
+	return Value{Kind: Binary, Text: string(data)}, nil
+}
+
+func bitsToNumber(bits string) string {
+	n := uint64(0)
+	for _, c := range bits {
+		n = n<<1 | uint64(c-'0')
+	}
+	return strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,230 @@
+package sqldump
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAll(script string) ([]*Row, *Reader, error) {
+	r := NewReader(strings.NewReader(script))
+	rows := []*Row{}
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return rows, r, nil
+		}
+		if err != nil {
+			return rows, r, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func str(text string) Value {
+	return Value{Kind: String, Text: text}
+}
+
+func num(text string) Value {
+	return Value{Kind: Number, Text: text}
+}
+
+func bin(text string) Value {
+	return Value{Kind: Binary, Text: text}
+}
+
+var null = Value{Kind: Null}
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values string
+		want   []Value
+	}{
+		{"backslash escapes", `'a\'b', 'c\\d', 'e\"f', 'x\0y', '\n\r\t\Z\b'`, []Value{str("a'b"), str(`c\d`), str(`e"f`), str("x\x00y"), str("\n\r\t\x1a\b")}},
+		{"like escapes keep their backslash", `'100\%', 'a\_b', '\q'`, []Value{str(`100\%`), str(`a\_b`), str("q")}},
+		{"doubled quotes", `'it''s', '''', "say ""hi"""`, []Value{str("it's"), str("'"), str(`say "hi"`)}},
+		{"empty string", `''`, []Value{str("")}},
+		{"delimiters in strings", `'a;b', 'c),(d', 'e
+f'`, []Value{str("a;b"), str("c),(d"), str("e\nf")}},
+		{"null", `NULL, null, 'NULL'`, []Value{null, null, str("NULL")}},
+		{"numbers", `0, 42, -7, +3, 12.50, -0.25, .5, 1e3, 2.5E-4`, []Value{num("0"), num("42"), num("-7"), num("+3"), num("12.50"), num("-0.25"), num(".5"), num("1e3"), num("2.5E-4")}},
+		{"hex literals", `0x48656C6C6F, X'00ff', x'', 0xABC`, []Value{bin("Hello"), bin("\x00\xff"), bin(""), bin("\x0a\xbc")}},
+		{"bit literal", `b'101'`, []Value{num("5")}},
+		{"character set introducers", `_binary 'a\0b', _utf8mb4 'c', _binary 0x01`, []Value{bin("a\x00b"), str("c"), bin("\x01")}},
+		{"words", `TRUE, CURRENT_TIMESTAMP`, []Value{{Kind: Word, Text: "TRUE"}, {Kind: Word, Text: "CURRENT_TIMESTAMP"}}},
+		{"utf-8", `'Достоевский', '東京'`, []Value{str("Достоевский"), str("東京")}},
+	}
+	for _, test := range tests {
+		rows, _, err := readAll("INSERT INTO `t` VALUES (" + test.values + ");")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(rows) != 1 || !reflect.DeepEqual(rows[0].Values, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, rows, test.want)
+		}
+	}
+}
+
+const books = "CREATE TABLE `books` (\n" +
+	"  `ID` int(15) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `Title` varchar(2000) DEFAULT '' COMMENT 'the title, with a comma',\n" +
+	"  `Price` decimal(10,2) DEFAULT NULL,\n" +
+	"  `MD5` char(32) NOT NULL,\n" +
+	"  PRIMARY KEY (`ID`),\n" +
+	"  UNIQUE KEY `MD5` (`MD5`),\n" +
+	"  KEY `Title` (`Title`(100))\n" +
+	") ENGINE=MyISAM DEFAULT CHARSET=utf8;\n"
+
+var booksTable = &Table{
+	Name: "books",
+	Columns: []Column{
+		{Name: "ID", Type: "int(15)"},
+		{Name: "Title", Type: "varchar(2000)"},
+		{Name: "Price", Type: "decimal(10,2)"},
+		{Name: "MD5", Type: "char(32)"},
+	},
+	PrimaryKey: []string{"ID"},
+}
+
+func TestScripts(t *testing.T) {
+	type row struct {
+		table   string
+		columns []string
+		values  []Value
+	}
+	bookColumns := []string{"ID", "Title", "Price", "MD5"}
+	tests := []struct {
+		name   string
+		script string
+		want   []row
+	}{
+		{"multi-row insert", books + "INSERT INTO `books` VALUES (1,'A',9.99,'a'),(2,'B',NULL,'b'),\n(3,'C',-1.5,'c');\n", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("9.99"), str("a")}},
+			{"books", bookColumns, []Value{num("2"), str("B"), null, str("b")}},
+			{"books", bookColumns, []Value{num("3"), str("C"), num("-1.5"), str("c")}},
+		}},
+		{"several inserts", books + "INSERT INTO `books` VALUES (1,'A',1,'a');\nINSERT INTO books VALUES (2,'B',2,'b');", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("1"), str("a")}},
+			{"books", bookColumns, []Value{num("2"), str("B"), num("2"), str("b")}},
+		}},
+		{"column list", books + "INSERT IGNORE INTO `db`.`books` (`MD5`, ID) VALUES ('a', 1);", []row{
+			{"books", []string{"MD5", "ID"}, []Value{str("a"), num("1")}},
+		}},
+		{"replace", books + "REPLACE INTO `books` VALUES (1,'A',1,'a');", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("1"), str("a")}},
+		}},
+		{"comments", "-- MySQL dump 10.13\n# a comment\n/*!40101 SET NAMES utf8 */;\n/* multi\nline; comment */\n" + books +
+			"LOCK TABLES `books` WRITE;\n/*!40000 ALTER TABLE `books` DISABLE KEYS */;\n" +
+			"INSERT INTO `books` VALUES -- values follow\n(1,'-- not a comment',/* inline */ 1,'#');\nUNLOCK TABLES;\n--\n", []row{
+			{"books", bookColumns, []Value{num("1"), str("-- not a comment"), num("1"), str("#")}},
+		}},
+		{"if not exists", strings.Replace(books, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1) + "INSERT INTO `books` VALUES (1,'A',1,'a');", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("1"), str("a")}},
+		}},
+		{"if not exists over lines", strings.Replace(books, "CREATE TABLE", "create table if\nnot exists", 1) + "INSERT INTO `books` VALUES (1,'A',1,'a');", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("1"), str("a")}},
+		}},
+		{"table named like if", "CREATE TABLE ifs (`a` int);\nINSERT INTO ifs VALUES (1);", []row{
+			{"ifs", []string{"a"}, []Value{num("1")}},
+		}},
+		{"delimiter", "DELIMITER ;;\nCREATE TRIGGER x BEFORE INSERT ON books FOR EACH ROW BEGIN SET @a = 1; END ;;\nDELIMITER ;\n" + books + "INSERT INTO books VALUES (1,'A',1,'a');", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("1"), str("a")}},
+		}},
+		{"without a final delimiter", books + "INSERT INTO books VALUES (1,'A',1,'a')", []row{
+			{"books", bookColumns, []Value{num("1"), str("A"), num("1"), str("a")}},
+		}},
+		{"no create table", "INSERT INTO `other` VALUES (1,'x');", []row{
+			{"other", []string{}, []Value{num("1"), str("x")}},
+		}},
+		{"nothing but statements", "SET NAMES utf8;\nDROP TABLE IF EXISTS `books`;\nINSERT INTO books SELECT * FROM old;\n", []row{}},
+	}
+	for _, test := range tests {
+		rows, _, err := readAll(test.script)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := []row{}
+		for _, r := range rows {
+			got = append(got, row{r.Table.Name, r.Columns, r.Values})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	_, r, err := readAll(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, ok := r.Table("BOOKS")
+	if !ok {
+		t.Fatal("books was not recorded")
+	}
+	if !reflect.DeepEqual(table, booksTable) {
+		t.Errorf("got %+v, want %+v", table, booksTable)
+	}
+	_, r, err = readAll("CREATE TABLE t (a int, b varchar(10), PRIMARY KEY (`a`, b(5)));")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table, _ := r.Table("t"); !reflect.DeepEqual(table.PrimaryKey, []string{"a", "b"}) {
+		t.Errorf("primary key %v, want [a b]", table.PrimaryKey)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"unterminated string", "INSERT INTO t VALUES (1,'abc"},
+		{"truncated after a value", "INSERT INTO t VALUES (1,'abc'"},
+		{"truncated after a comma", "INSERT INTO t VALUES (1,"},
+		{"truncated after a row", "INSERT INTO t VALUES (1,2),"},
+		{"truncated column list", "INSERT INTO t (a, b"},
+		{"truncated primary key", "CREATE TABLE t (a int, PRIMARY KEY (a"},
+		{"truncated create table", "CREATE TABLE t (a int, b"},
+		{"unterminated comment", "/* never ends"},
+		{"wrong value count", books + "INSERT INTO books VALUES (1,'A');"},
+		{"garbage between values", "INSERT INTO t VALUES (1 2);"},
+		{"invalid hex", "INSERT INTO t VALUES (X'zz');"},
+	}
+	for _, test := range tests {
+		rows, r, err := readAll(test.script)
+		if err == nil {
+			t.Errorf("%s: read %d rows without an error", test.name, len(rows))
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			t.Errorf("%s: got io.EOF, a truncated script must not look complete", test.name)
+		}
+		if r.Line() < 1 {
+			t.Errorf("%s: line %d", test.name, r.Line())
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	r := NewReader(strings.NewReader("-- header\n\nINSERT INTO t VALUES\n(1,'a\nb'),\n(2,'c');"))
+	lines := []int{}
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, r.Line())
+	}
+	if !reflect.DeepEqual(lines, []int{5, 6}) {
+		t.Errorf("lines %v, want [5 6]", lines)
+	}
+}
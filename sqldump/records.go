@@ -0,0 +1,276 @@
+package sqldump
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Book is a row of the updated table, the main libgen catalogue.
+type Book struct {
+	ID               int64     `sql:"ID"`
+	Title            string    `sql:"Title"`
+	VolumeInfo       string    `sql:"VolumeInfo"`
+	Series           string    `sql:"Series"`
+	Periodical       string    `sql:"Periodical"`
+	Author           string    `sql:"Author"`
+	Year             string    `sql:"Year"`
+	Edition          string    `sql:"Edition"`
+	Publisher        string    `sql:"Publisher"`
+	City             string    `sql:"City"`
+	Pages            string    `sql:"Pages"`
+	PagesInFile      int64     `sql:"PagesInFile"`
+	Language         string    `sql:"Language"`
+	Topic            string    `sql:"Topic"`
+	Library          string    `sql:"Library"`
+	Issue            string    `sql:"Issue"`
+	Identifier       string    `sql:"Identifier"`
+	ISSN             string    `sql:"ISSN"`
+	ASIN             string    `sql:"ASIN"`
+	UDC              string    `sql:"UDC"`
+	LBC              string    `sql:"LBC"`
+	DDC              string    `sql:"DDC"`
+	LCC              string    `sql:"LCC"`
+	DOI              string    `sql:"Doi"`
+	GoogleBookID     string    `sql:"Googlebookid"`
+	OpenLibraryID    string    `sql:"OpenLibraryID"`
+	Commentary       string    `sql:"Commentary"`
+	DPI              int64     `sql:"DPI"`
+	Color            string    `sql:"Color"`
+	Cleaned          string    `sql:"Cleaned"`
+	Orientation      string    `sql:"Orientation"`
+	Paginated        string    `sql:"Paginated"`
+	Scanned          string    `sql:"Scanned"`
+	Bookmarked       string    `sql:"Bookmarked"`
+	Searchable       string    `sql:"Searchable"`
+	Filesize         int64     `sql:"Filesize"`
+	Extension        string    `sql:"Extension"`
+	MD5              string    `sql:"MD5"`
+	Generic          string    `sql:"Generic"`
+	Visible          string    `sql:"Visible"`
+	Locator          string    `sql:"Locator"`
+	Local            int64     `sql:"Local"`
+	TimeAdded        time.Time `sql:"TimeAdded"`
+	TimeLastModified time.Time `sql:"TimeLastModified"`
+	CoverURL         string    `sql:"Coverurl"`
+	Tags             string    `sql:"Tags"`
+	IdentifierWODash string    `sql:"IdentifierWODash"`
+}
+
+// Description is a row of the description table, keyed by the MD5 of a book.
+type Description struct {
+	ID               int64     `sql:"id"`
+	MD5              string    `sql:"md5"`
+	Description      string    `sql:"descr"`
+	TOC              string    `sql:"toc"`
+	TimeLastModified time.Time `sql:"TimeLastModified"`
+}
+
+// Topic is a row of the topics table, every topic has a row per language.
+type Topic struct {
+	ID          int64  `sql:"id"`
+	Description string `sql:"topic_descr"`
+	Lang        string `sql:"lang"`
+	KolxozCode  string `sql:"kolxoz_code"`
+	TopicID     int64  `sql:"topic_id"`
+	ParentID    int64  `sql:"topic_id_hl"`
+}
+
+// Hash is a row of the hashes table, the digests of a book file by its MD5.
+type Hash struct {
+	MD5     string `sql:"md5"`
+	CRC32   string `sql:"crc32"`
+	EDonkey string `sql:"edonkey"`
+	AICH    string `sql:"aich"`
+	SHA1    string `sql:"sha1"`
+	TTH     string `sql:"tth"`
+	Torrent string `sql:"torrent"`
+	BTIH    string `sql:"btih"`
+	SHA256  string `sql:"sha256"`
+	IPFSCID string `sql:"ipfs_cid"`
+}
+
+// Records maps the tables with a record type to a constructor of the record.
+var Records = map[string]func() any{
+	"updated":     func() any { return &Book{} },
+	"description": func() any { return &Description{} },
+	"topics":      func() any { return &Topic{} },
+	"hashes":      func() any { return &Hash{} },
+}
+
+// NextRecord returns the next row of a table in Records decoded into its
+// record type, rows of other tables are skipped. io.EOF is returned at the
+// end of the script.
+func (r *Reader) NextRecord() (any, error) {
+	for {
+		row, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		create, ok := Records[strings.ToLower(row.Table.Name)]
+		if !ok {
+			continue
+		}
+		record := create()
+		if err = row.Decode(record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.Line(), err)
+		}
+		return record, nil
+	}
+}
+
+// RecordTable returns the schema of a table with a record type, the column
+// types are derived from the record fields.
+func RecordTable(name string) (*Table, bool) {
+	create, ok := Records[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	t := reflect.TypeOf(create()).Elem()
+	fields := fieldsOf(t)
+	table := Table{Name: name}
+	for _, column := range fields.order {
+		field := t.Field(fields.byName[strings.ToLower(column)])
+		columnType := "text"
+		switch {
+		case field.Type == timeType:
+			columnType = "datetime"
+		case field.Type.Kind() >= reflect.Int && field.Type.Kind() <= reflect.Uint64:
+			columnType = "bigint"
+		case field.Type.Kind() == reflect.Float32 || field.Type.Kind() == reflect.Float64:
+			columnType = "double"
+		}
+		table.Columns = append(table.Columns, Column{Name: column, Type: columnType})
+	}
+	return &table, true
+}
+
+// Schema returns the schema of the table of the row. Without a CREATE TABLE
+// the column list of the INSERT is used as text columns and the known libgen
+// tables fall back to their RecordTable.
+func (r *Row) Schema() (*Table, error) {
+	if len(r.Table.Columns) > 0 {
+		return r.Table, nil
+	}
+	if len(r.Columns) > 0 {
+		table := Table{Name: r.Table.Name}
+		for _, name := range r.Columns {
+			table.Columns = append(table.Columns, Column{Name: name, Type: "text"})
+		}
+		return &table, nil
+	}
+	known, ok := RecordTable(r.Table.Name)
+	if !ok || len(known.Columns) != len(r.Values) {
+		return nil, fmt.Errorf("%s: %w", r.Table.Name, ErrNoColumns)
+	}
+	return known, nil
+}
+
+// ErrNoColumns is returned when a row is decoded whose table had neither a
+// CREATE TABLE statement nor a column list in its INSERT and whose values do
+// not line up with the record.
+var ErrNoColumns = errors.New("the columns of the table are unknown")
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type structFields struct {
+	// byName maps a lower case sql tag to its field
+	byName map[string]int
+	// order lists the tags in field order
+	order []string
+}
+
+// fieldCache maps a struct type to its structFields.
+var fieldCache = sync.Map{}
+
+func fieldsOf(t reflect.Type) *structFields {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(*structFields)
+	}
+	fields := structFields{byName: map[string]int{}}
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("sql"); len(tag) > 0 && tag != "-" {
+			fields.byName[strings.ToLower(tag)] = i
+			fields.order = append(fields.order, tag)
+		}
+	}
+	fieldCache.Store(t, &fields)
+	return &fields
+}
+
+// Decode sets the fields of the struct dst points to from the columns named
+// by their sql tag, columns are matched case-insensitively and columns
+// missing from the row leave their field alone. Rows of a table whose columns
+// are unknown are decoded in field order when the counts agree, the record
+// types list their columns in the order of the libgen schema.
+func (r *Row) Decode(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode expects a pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	fields := fieldsOf(v.Type())
+	columns := r.Columns
+	if len(columns) == 0 {
+		if len(fields.order) != len(r.Values) {
+			return fmt.Errorf("%s: %w", r.Table.Name, ErrNoColumns)
+		}
+		columns = fields.order
+	}
+	for i, name := range columns {
+		index, ok := fields.byName[strings.ToLower(name)]
+		if !ok || i >= len(r.Values) {
+			continue
+		}
+		if err := setField(v.Field(index), r.Values[i]); err != nil {
+			return fmt.Errorf("%s.%s: %w", r.Table.Name, name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value Value) error {
+	if field.Type() == timeType {
+		t, err := value.Time()
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value.Text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := value.Int()
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := value.Int()
+		if err != nil {
+			return err
+		}
+		field.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, err := value.Float()
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		field.SetBool(value.Bool())
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		field.SetBytes(value.Bytes())
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
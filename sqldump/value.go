@@ -0,0 +1,98 @@
+package sqldump
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+	Null Kind = iota
+	String
+	Number
+	// Binary values come from hex literals and _binary strings.
+	Binary
+	// Word is any other bare word, e.g. TRUE or CURRENT_TIMESTAMP.
+	Word
+)
+
+// Value is a literal of an INSERT statement, Text holds it unescaped.
+type Value struct {
+	Kind Kind
+	Text string
+}
+
+// TimeLayout is the layout of DATETIME and TIMESTAMP values.
+const TimeLayout = "2006-01-02 15:04:05"
+
+func (v Value) IsNull() bool {
+	return v.Kind == Null
+}
+
+func (v Value) String() string {
+	return v.Text
+}
+
+func (v Value) Bytes() []byte {
+	if v.Kind == Null {
+		return nil
+	}
+	return []byte(v.Text)
+}
+
+// Int parses the value as an integer, NULL and the empty string are 0.
+func (v Value) Int() (int64, error) {
+	text := strings.TrimSpace(v.Text)
+	if v.Kind == Null || len(text) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		// decimals and floats written to an integer field
+		f, ferr := strconv.ParseFloat(text, 64)
+		if ferr != nil {
+			return 0, err
+		}
+		return int64(f), nil
+	}
+	return n, nil
+}
+
+// Float parses the value as a float, NULL and the empty string are 0.
+func (v Value) Float() (float64, error) {
+	text := strings.TrimSpace(v.Text)
+	if v.Kind == Null || len(text) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// Bool is true for non zero numbers, TRUE, Y and YES.
+func (v Value) Bool() bool {
+	switch strings.ToUpper(strings.TrimSpace(v.Text)) {
+	case "", "0", "FALSE", "N", "NO":
+		return false
+	}
+	if f, err := v.Float(); err == nil {
+		return f != 0
+	}
+	return v.Kind != Null
+}
+
+// Time parses a DATETIME, TIMESTAMP or DATE value in UTC. NULL and the zero
+// dates MySQL allows are the zero time.
+func (v Value) Time() (time.Time, error) {
+	text := strings.TrimSpace(v.Text)
+	if v.Kind == Null || len(text) == 0 || strings.HasPrefix(text, "0000-00-00") {
+		return time.Time{}, nil
+	}
+	layout := TimeLayout
+	if len(text) == len("2006-01-02") {
+		layout = "2006-01-02"
+	} else if len(text) > len(TimeLayout) {
+		layout = TimeLayout + ".999999"
+	}
+	return time.Parse(layout, text)
+}
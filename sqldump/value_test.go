@@ -0,0 +1,70 @@
+package sqldump
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		value Value
+		i     int64
+		iErr  bool
+		f     float64
+		fErr  bool
+		b     bool
+	}{
+		{null, 0, false, 0, false, false},
+		{str(""), 0, false, 0, false, false},
+		{num("42"), 42, false, 42, false, true},
+		{num("-7"), -7, false, -7, false, true},
+		{num("12.50"), 12, false, 12.5, false, true},
+		{num("-0.25"), 0, false, -0.25, false, true},
+		{num("1e3"), 1000, false, 1000, false, true},
+		{str(" 3 "), 3, false, 3, false, true},
+		{num("0"), 0, false, 0, false, false},
+		{str("YES"), 0, true, 0, true, true},
+		{str("no"), 0, true, 0, true, false},
+		{Value{Kind: Word, Text: "TRUE"}, 0, true, 0, true, true},
+		{str("abc"), 0, true, 0, true, true},
+	}
+	for _, test := range tests {
+		i, err := test.value.Int()
+		if (err != nil) != test.iErr || (err == nil && i != test.i) {
+			t.Errorf("%q.Int() = %d, %v, want %d", test.value.Text, i, err, test.i)
+		}
+		f, err := test.value.Float()
+		if (err != nil) != test.fErr || (err == nil && f != test.f) {
+			t.Errorf("%q.Float() = %g, %v, want %g", test.value.Text, f, err, test.f)
+		}
+		if b := test.value.Bool(); b != test.b {
+			t.Errorf("%q.Bool() = %v, want %v", test.value.Text, b, test.b)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	tests := []struct {
+		value Value
+		want  time.Time
+		err   bool
+	}{
+		{str("2023-09-05 12:30:01"), time.Date(2023, 9, 5, 12, 30, 1, 0, time.UTC), false},
+		{str("2023-09-05"), time.Date(2023, 9, 5, 0, 0, 0, 0, time.UTC), false},
+		{str("2023-09-05 12:30:01.250"), time.Date(2023, 9, 5, 12, 30, 1, 250e6, time.UTC), false},
+		{str("0000-00-00 00:00:00"), time.Time{}, false},
+		{str("0000-00-00"), time.Time{}, false},
+		{str(""), time.Time{}, false},
+		{null, time.Time{}, false},
+		{str("yesterday"), time.Time{}, true},
+	}
+	for _, test := range tests {
+		got, err := test.value.Time()
+		if (err != nil) != test.err || !got.Equal(test.want) {
+			t.Errorf("%q.Time() = %v, %v, want %v", test.value.Text, got, err, test.want)
+		}
+	}
+	if b := null.Bytes(); b != nil {
+		t.Errorf("NULL.Bytes() = %q, want nil", b)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !linux && !darwin && !freebsd && !dragonfly
+
+package utils
+
+import "errors"
+
+// FreeSpace is not implemented on this platform, callers skip the check.
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package utils
+
+import "syscall"
+
+// FreeSpace returns the bytes available to the current user on the volume
+// holding path.
+func FreeSpace(path string) (int64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
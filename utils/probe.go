@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"libgen/retry"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The probe targets that are not urls.
+const (
+	// ProbeMirror probes the mirrors themselves, for networks that only
+	// reach them.
+	ProbeMirror   = "mirror"
+	ProbeDisabled = "disabled"
+)
+
+// DefaultProbe answers 204 to anyone on the internet.
+const DefaultProbe = "http://clients3.google.com/generate_204"
+
+// Probe is the connectivity check WaitForConnection runs before requests.
+type Probe struct {
+	// Target is a url, ProbeMirror or ProbeDisabled.
+	Target string
+	// Mirrors returns the urls probed when Target is ProbeMirror, the
+	// connection is up when any of them answers.
+	Mirrors func() []string
+	// Timeout is how long WaitForConnection waits, 0 waits until ctx is
+	// done.
+	Timeout time.Duration
+	// Cache is how long a successful probe is trusted.
+	Cache time.Duration
+}
+
+// The probe timeout and cache interval unless configured otherwise.
+const (
+	DefaultProbeTimeout = time.Minute * 10
+	DefaultProbeCache   = time.Second * 30
+)
+
+// ErrOffline is returned once the probe failed for the whole timeout.
+var ErrOffline = errors.New("no connection")
+
+var (
+	probe = Probe{Target: DefaultProbe, Timeout: DefaultProbeTimeout, Cache: DefaultProbeCache}
+	// probeLck guards the probe and its results, it is not held while the
+	// probe runs
+	probeLck    sync.Mutex
+	probedAt    time.Time
+	probeFailed bool
+	// probing is the probe in flight, parts starting together share its
+	// result
+	probing *probeCall
+)
+
+type probeCall struct {
+	// done is closed once ok and aborted are set
+	done chan struct{}
+	ok   bool
+	// aborted is set when the ctx of the caller running the probe ended it
+	aborted bool
+}
+
+// SetProbe validates and applies the connectivity check.
+func SetProbe(p Probe) error {
+	switch p.Target {
+	case "":
+		p.Target = DefaultProbe
+	case ProbeMirror, ProbeDisabled:
+	default:
+		u, err := url.Parse(p.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("invalid probe %q, expected a http url, %s or %s", p.Target, ProbeMirror, ProbeDisabled)
+		}
+	}
+	probeLck.Lock()
+	defer probeLck.Unlock()
+	probe = p
+	probedAt = time.Time{}
+	// the probe in flight checks the old target
+	probing = nil
+	return nil
+}
+
+// InternetIsWorking probes the connectivity target, any answer counts. A
+// successful probe is trusted for the cache interval of the probe, callers
+// arriving while a probe runs wait for its result.
+func InternetIsWorking(ctx context.Context) bool {
+	probeLck.Lock()
+	if probe.Target == ProbeDisabled || (!probedAt.IsZero() && time.Since(probedAt) < probe.Cache) {
+		probeLck.Unlock()
+		return true
+	}
+	if call := probing; call != nil {
+		probeLck.Unlock()
+		select {
+		case <-call.done:
+			if call.aborted {
+				return InternetIsWorking(ctx)
+			}
+			return call.ok
+		case <-ctx.Done():
+			return false
+		}
+	}
+	call := &probeCall{done: make(chan struct{})}
+	probing = call
+	p := probe
+	probeLck.Unlock()
+
+	targets := []string{p.Target}
+	if p.Target == ProbeMirror && p.Mirrors != nil {
+		targets = p.Mirrors()
+	}
+	for _, target := range targets {
+		if call.ok = probeTarget(ctx, target); call.ok {
+			break
+		}
+	}
+
+	call.aborted = !call.ok && ctx.Err() != nil
+	probeLck.Lock()
+	if probing == call {
+		probing = nil
+		if call.ok {
+			probedAt = time.Now()
+			if probeFailed {
+				fmt.Println("The connection is back")
+				probeFailed = false
+			}
+		} else if !probeFailed && ctx.Err() == nil {
+			fmt.Printf("No connection, %s did not answer\n", p.Target)
+			probeFailed = true
+		}
+	}
+	probeLck.Unlock()
+	close(call.done)
+	return call.ok
+}
+
+func probeTarget(ctx context.Context, target string) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", USERAGENT)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return true
+}
+
+// WaitForConnection blocks until the probe succeeds. It returns the error
+// of ctx when ctx is done first and ErrOffline, which is not worth
+// retrying, once the probe failed for its whole timeout.
+func WaitForConnection(ctx context.Context) error {
+	probeLck.Lock()
+	timeout := probe.Timeout
+	probeLck.Unlock()
+	started := time.Now()
+	for !InternetIsWorking(ctx) {
+		if timeout > 0 && time.Since(started) >= timeout {
+			return retry.Permanent(fmt.Errorf("%w for %s", ErrOffline, timeout))
+		}
+		if err := Sleep(ctx, time.Millisecond*1500); err != nil {
+			return err
+		}
+	}
+	return nil
+}
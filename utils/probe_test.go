@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"libgen/retry"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probeServer answers every request after delay and counts them.
+func probeServer(t *testing.T, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+// offline returns the url of a server that is no longer running.
+func offline() string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+func setProbe(t *testing.T, p Probe) {
+	probeLck.Lock()
+	old := probe
+	probeLck.Unlock()
+	t.Cleanup(func() { SetProbe(old) })
+	if err := SetProbe(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbeCache(t *testing.T) {
+	srv, requests := probeServer(t, 0)
+	setProbe(t, Probe{Target: srv.URL, Cache: 200 * time.Millisecond})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if !InternetIsWorking(ctx) {
+			t.Fatal("the probe failed")
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d probes within the cache interval, want 1", n)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if !InternetIsWorking(ctx) {
+		t.Fatal("the probe failed")
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d probes after the cache interval, want 2", n)
+	}
+
+	// a new probe is not answered from the cache of the old one
+	setProbe(t, Probe{Target: offline(), Cache: time.Hour})
+	if InternetIsWorking(ctx) {
+		t.Error("an offline target passed the probe")
+	}
+}
+
+func TestProbeShared(t *testing.T) {
+	srv, requests := probeServer(t, 100*time.Millisecond)
+	setProbe(t, Probe{Target: srv.URL, Cache: time.Hour})
+	wg := sync.WaitGroup{}
+	failed := atomic.Int32{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !InternetIsWorking(context.Background()) {
+				failed.Add(1)
+			}
+		}()
+	}
+	// the lock is not held while the probe runs
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	probeLck.Lock()
+	probeLck.Unlock()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("waited %s for the lock during a probe", elapsed)
+	}
+	wg.Wait()
+	if failed.Load() > 0 {
+		t.Errorf("%d callers saw the probe fail", failed.Load())
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d probes for callers arriving together, want 1", n)
+	}
+
+	// a caller waiting for the probe of another one stops with its ctx
+	setProbe(t, Probe{Target: srv.URL})
+	go InternetIsWorking(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if InternetIsWorking(ctx) {
+		t.Error("a cancelled caller saw the probe succeed")
+	}
+
+	// callers waiting for a probe cut short by its ctx probe again
+	setProbe(t, Probe{Target: srv.URL})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	go InternetIsWorking(ctx)
+	time.Sleep(5 * time.Millisecond)
+	if !InternetIsWorking(context.Background()) {
+		t.Error("the probe failed for a caller after another one was cancelled")
+	}
+}
+
+func TestProbeTargets(t *testing.T) {
+	srv, requests := probeServer(t, 0)
+	ctx := context.Background()
+	setProbe(t, Probe{Target: ProbeDisabled})
+	if !InternetIsWorking(ctx) || requests.Load() != 0 {
+		t.Error("a disabled probe sent requests or failed")
+	}
+	down := offline()
+	setProbe(t, Probe{Target: ProbeMirror, Mirrors: func() []string { return []string{down, srv.URL} }})
+	if !InternetIsWorking(ctx) || requests.Load() != 1 {
+		t.Error("the probe failed with one mirror up")
+	}
+	setProbe(t, Probe{Target: ProbeMirror, Mirrors: func() []string { return []string{down} }})
+	if InternetIsWorking(ctx) {
+		t.Error("the probe passed with every mirror down")
+	}
+	for _, target := range []string{"ftp://example.com", "example.com", "http://"} {
+		if err := SetProbe(Probe{Target: target}); err == nil {
+			t.Errorf("SetProbe accepted %q", target)
+		}
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	setProbe(t, Probe{Target: offline(), Timeout: 100 * time.Millisecond})
+	err := WaitForConnection(context.Background())
+	if !errors.Is(err, ErrOffline) || !retry.IsPermanent(err) {
+		t.Errorf("WaitForConnection = %v, want a permanent %v", err, ErrOffline)
+	}
+
+	setProbe(t, Probe{Target: offline()})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := WaitForConnection(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitForConnection = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	srv, _ := probeServer(t, 0)
+	setProbe(t, Probe{Target: srv.URL, Timeout: time.Millisecond})
+	if err := WaitForConnection(context.Background()); err != nil {
+		t.Errorf("WaitForConnection = %v with the target up", err)
+	}
+}
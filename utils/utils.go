@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"libgen/retry"
+	"math"
 	"net/http"
 
 	"os"
@@ -12,25 +15,22 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
 )
 
 var (
-	kernel32        = syscall.NewLazyDLL("kernel32.dll")
-	procCreateMutex = kernel32.NewProc("CreateMutexW")
-	user32          = syscall.MustLoadDLL("user32.dll")
-	MAX_FILE_SIZE   = int64(1024) * 1024 * 50
-	USERAGENT       = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/93.0.4573.0 Safari/537.36"
+	MAX_FILE_SIZE = int64(1024) * 1024 * 50
+	USERAGENT     = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/93.0.4573.0 Safari/537.36"
 )
 
 // var BaseUri = "http://localhost:3000"
 var MUTEX = "libgendownloader"
 
-func GetResponse(uri string, headers *map[string]string) (*http.Response, error) {
+// GetResponse sends a GET request, the request is aborted when ctx is done.
+func GetResponse(ctx context.Context, uri string, headers *map[string]string) (*http.Response, error) {
 	client := &http.Client{
 		Jar: http.DefaultClient.Jar,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -43,7 +43,7 @@ func GetResponse(uri string, headers *map[string]string) (*http.Response, error)
 			return nil
 		},
 	}
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -67,11 +67,43 @@ func GetResponse(uri string, headers *map[string]string) (*http.Response, error)
 
 	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
 		defer resp.Body.Close()
-		return nil, fmt.Errorf("received status code %d", resp.StatusCode)
+		return nil, NewStatusError(resp)
 	}
 	return resp, nil
 }
 
+// StatusError is returned for a response that is not a success, together
+// with the wait a 429 or 503 response asked for in Retry-After.
+type StatusError struct {
+	Code  int
+	After time.Duration
+}
+
+// NewStatusError describes the status of resp.
+func NewStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{Code: resp.StatusCode}
+	if value := resp.Header.Get("Retry-After"); len(value) > 0 {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			e.After = time.Second * time.Duration(seconds)
+		} else if date, err := http.ParseTime(value); err == nil {
+			e.After = time.Until(date)
+		}
+	}
+	return e
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received status code %d", e.Code)
+}
+
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.After
+}
+
 func ReplaceInvalidFileChars(file string) string {
 	chars := `[\\/:*?""<>|]`
 
@@ -88,29 +120,12 @@ func GetAssetDir() string {
 
 	dir := filepath.Join(GetBaseDirectory(), "asset")
 	if !Exists(dir) {
-		os.MkdirAll(dir, 0655)
+		os.MkdirAll(dir, 0755)
 		SetHidden(dir)
 	}
 	return dir
 }
 
-func CreateMutex(name string) (uintptr, error) {
-	ret, _, err := procCreateMutex.Call(
-		0,
-		0,
-		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
-	)
-	switch int(err.(syscall.Errno)) {
-	case 0:
-		return ret, nil
-	default:
-		return ret, err
-	}
-}
-func FirstInstance() bool {
-	_, err := CreateMutex(MUTEX)
-	return err == nil
-}
 func MoveOrCopyFile(src, dest string) error {
 	err := os.Rename(src, dest)
 	if err == nil {
@@ -185,6 +200,34 @@ func FormatBytes(bytes int64) string {
 	}
 	return fmt.Sprintf("%.2f %s", value, units[i])
 }
+
+// ParseBytes parses a size written like FormatBytes does, e.g. 512KB, 1.5 MB
+// or a plain number of bytes.
+func ParseBytes(size string) (int64, error) {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	text := strings.ToUpper(strings.TrimSpace(size))
+	number := strings.TrimRightFunc(text, func(r rune) bool {
+		return r == ' ' || (r >= 'A' && r <= 'Z')
+	})
+	unit := strings.TrimSpace(text[len(number):])
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	if len(unit) == 1 && unit != "B" {
+		unit += "B"
+	}
+	for i, u := range units {
+		if u == unit || (i == 0 && len(unit) == 0) {
+			// float64(math.MaxInt64) rounds up to 2^63
+			if value *= math.Pow(1024, float64(i)); value >= math.MaxInt64 {
+				return 0, fmt.Errorf("size %q is too large", size)
+			}
+			return int64(value), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q, expected a unit like KB, MB or GB", size)
+}
 func RemoveEmptyFromSlice(src []string) []string {
 	final := make([]string, 0, 20)
 	for _, el := range src {
@@ -198,7 +241,7 @@ func RemoveEmptyFromSlice(src []string) []string {
 }
 func ExecuteProcess(program string, args ...string) (string, error) {
 	cmd := exec.Command(program, args...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: 0x08000000}
+	hideWindow(cmd)
 	out, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -234,18 +277,6 @@ func RemoveExt(path string) string {
 	return base
 }
 
-func KillFile(file string) {
-	base := filepath.Base(file)
-	cmd := exec.Command("taskkill", "/IM", base, "/F")
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: 0x08000000}
-	cmd.Run()
-}
-func KillByPID(pid int) {
-	cmd := exec.Command("taskkill", "/PID", fmt.Sprintf("%d", pid), "/F")
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: 0x08000000}
-	cmd.Run()
-}
-
 type Info struct {
 	FullPath string
 	Info     os.FileInfo
@@ -265,9 +296,29 @@ func GetInfosFromDir(dir string) []Info {
 	return infos
 }
 func DeleteAllFiles(dir string) {
+	DeleteAllFilesExcept(dir)
+}
+
+// DeleteAllFilesExcept removes everything in dir but the entries named in
+// keep, e.g. a directory of files that outlive the downloads.
+func DeleteAllFilesExcept(dir string, keep ...string) {
 	killWg := sync.WaitGroup{}
 	hasExecs := false
 	infs := GetInfosFromDir(dir)
+	if len(keep) > 0 {
+		kept := make([]Info, 0, len(infs))
+		for _, inf := range infs {
+			rel, err := filepath.Rel(dir, inf.FullPath)
+			if err != nil || rel == "." {
+				continue
+			}
+			top := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+			if !InSlice(keep, top, false) {
+				kept = append(kept, inf)
+			}
+		}
+		infs = kept
+	}
 
 	for _, inf := range infs {
 		hasExecs = true
@@ -310,44 +361,52 @@ func GetDownloadsDir() string {
 	}
 	return dl
 }
-func GetData(address string) ([]byte, error) {
-	WaitForConnection()
-	res, err := http.Get(address)
-	errCount := 0
-	for err != nil {
-		errCount++
-		WaitForConnection()
-		res, err = http.Get(address)
-		if errCount > 5 {
-			return []byte{}, err
+func GetData(ctx context.Context, address string) ([]byte, error) {
+	var data []byte
+	err := retry.Do(ctx, "get", address, func(attempt int) error {
+		if err := WaitForConnection(ctx); err != nil {
+			return err
 		}
-		time.Sleep(1500 * time.Millisecond)
-	}
-	defer res.Body.Close()
-	if !(res.StatusCode >= 200 && res.StatusCode < 300) {
-		return []byte{}, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
-	}
-	htmlbytes, err := ioutil.ReadAll(res.Body)
+		res, err := getContext(ctx, address)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if !(res.StatusCode >= 200 && res.StatusCode < 300) {
+			return NewStatusError(res)
+		}
+		data, err = ioutil.ReadAll(res.Body)
+		return err
+	})
 	if err != nil {
 		return []byte{}, err
 	}
-	return htmlbytes, nil
+	return data, nil
 }
 func GetBaseDirectory() string {
 
 	exe, _ := os.Executable()
 	return filepath.Dir(exe)
 }
-func InternetIsWorking() bool {
-	_, err := http.Get("http://clients3.google.com/generate_204")
-	return err == nil
-}
-func WaitForConnection() {
 
-	for !InternetIsWorking() {
-		time.Sleep(time.Millisecond * 1500)
+// Sleep pauses for d, it returns the error of ctx when ctx is done first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
+}
 
+func getContext(ctx context.Context, address string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
 }
 
 func ParseCommandline(line string) []string {
@@ -453,7 +512,7 @@ func Exists(file string) bool {
 	return true
 }
 func WriteFile(path string, data []byte) error {
-	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0755)
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -471,7 +530,7 @@ func WriteFile(path string, data []byte) error {
 	return err
 }
 func AppendFile(path string, data []byte) error {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -524,36 +583,6 @@ func InSlice(list []string, search string, strict bool) bool {
 	return false
 }
 
-func ExecCommandExists(file string) bool {
-	if Exists(file) {
-		return true
-	}
-	cmd := exec.Command("where", file)
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: 0x08000000}
-	res, err := cmd.Output()
-	if err == nil {
-		for _, line := range strings.Split(string(res), "\n") {
-			if Exists(strings.TrimSpace(line)) {
-				return true
-			}
-		}
-	}
-	return false
-}
-
-func SetHidden(path string) error {
-	filenameW, err := syscall.UTF16PtrFromString(path)
-	if err != nil {
-		return err
-	}
-
-	err = syscall.SetFileAttributes(filenameW, syscall.FILE_ATTRIBUTE_HIDDEN)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
 func RemoveFromSlice(lst []string, search string) bool {
 	for i := 0; i < len(lst); i++ {
 		if lst[i] == search {
@@ -0,0 +1,74 @@
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// instanceLock keeps the lock file open for the lifetime of the process, the
+// kernel drops the lock when the descriptor is closed or the process dies.
+var instanceLock *os.File
+
+func hideWindow(cmd *exec.Cmd) {
+}
+
+func CreateMutex(name string) (uintptr, error) {
+	if instanceLock != nil {
+		// closing a second descriptor of the file would drop the lock
+		return instanceLock.Fd(), nil
+	}
+	lockFile := filepath.Join(GetBaseDirectory(), "."+name+".lock")
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	// fcntl locks exist on every unix, flock does not on solaris and aix
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	err = syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	instanceLock = file
+	return file.Fd(), nil
+}
+
+// ShellCommand returns a command running line with the system shell, killed
+// when ctx is done.
+func ShellCommand(ctx context.Context, line string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", line)
+	hideWindow(cmd)
+	return cmd
+}
+func FirstInstance() bool {
+	_, err := CreateMutex(MUTEX)
+	return err == nil
+}
+func KillFile(file string) {
+	base := filepath.Base(file)
+	cmd := exec.Command("pkill", "-KILL", "-x", base)
+	cmd.Run()
+}
+func KillByPID(pid int) {
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+func ExecCommandExists(file string) bool {
+	if Exists(file) {
+		return true
+	}
+	_, err := exec.LookPath(file)
+	return err == nil
+}
+
+// SetHidden is a no-op outside windows, hidden files are a naming convention
+// there and renaming the directory would break callers holding its path.
+func SetHidden(path string) error {
+	return nil
+}
@@ -0,0 +1,112 @@
+//go:build windows
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32        = syscall.NewLazyDLL("kernel32.dll")
+	procCreateMutex = kernel32.NewProc("CreateMutexW")
+	procDiskFree    = kernel32.NewProc("GetDiskFreeSpaceExW")
+	user32          = syscall.MustLoadDLL("user32.dll")
+)
+
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: 0x08000000}
+}
+
+func CreateMutex(name string) (uintptr, error) {
+	ret, _, err := procCreateMutex.Call(
+		0,
+		0,
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+	)
+	switch int(err.(syscall.Errno)) {
+	case 0:
+		return ret, nil
+	default:
+		return ret, err
+	}
+}
+
+// ShellCommand returns a command running line with the system shell, killed
+// when ctx is done.
+func ShellCommand(ctx context.Context, line string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd", "/C", line)
+	hideWindow(cmd)
+	return cmd
+}
+func FirstInstance() bool {
+	_, err := CreateMutex(MUTEX)
+	return err == nil
+}
+func KillFile(file string) {
+	base := filepath.Base(file)
+	cmd := exec.Command("taskkill", "/IM", base, "/F")
+	hideWindow(cmd)
+	cmd.Run()
+}
+func KillByPID(pid int) {
+	cmd := exec.Command("taskkill", "/PID", fmt.Sprintf("%d", pid), "/F")
+	hideWindow(cmd)
+	cmd.Run()
+}
+
+func ExecCommandExists(file string) bool {
+	if Exists(file) {
+		return true
+	}
+	cmd := exec.Command("where", file)
+	hideWindow(cmd)
+	res, err := cmd.Output()
+	if err == nil {
+		for _, line := range strings.Split(string(res), "\n") {
+			if Exists(strings.TrimSpace(line)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func SetHidden(path string) error {
+	filenameW, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.SetFileAttributes(filenameW, syscall.FILE_ATTRIBUTE_HIDDEN)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FreeSpace returns the bytes available to the current user on the volume
+// holding path.
+func FreeSpace(path string) (int64, error) {
+	pathW, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	ret, _, err := procDiskFree.Call(
+		uintptr(unsafe.Pointer(pathW)),
+		uintptr(unsafe.Pointer(&available)),
+		0,
+		0,
+	)
+	if ret == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
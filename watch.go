@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"libgen/config"
+	"libgen/dumps"
+	"libgen/hooks"
+	"libgen/manifest"
+	"libgen/mirrors"
+	"libgen/schedule"
+	"libgen/snapshot"
+	"libgen/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultSchedule checks the mirrors every night.
+const DefaultSchedule = "0 3 * * *"
+
+// Watch is the schedule of the watch command and the commands it runs on
+// every new dump.
+var Watch = config.Watch{Schedule: DefaultSchedule}
+
+// watchSteps maps the commands watch can run on a new dump to a function
+// running them with the dump file and the arguments of the step.
+var watchSteps = map[string]func(ctx context.Context, family *dumps.Family, file string, args []string) int{
+	"verify": func(ctx context.Context, family *dumps.Family, file string, args []string) int {
+		return runVerify(ctx, []string{file})
+	},
+	"extract": func(ctx context.Context, family *dumps.Family, file string, args []string) int {
+		return runExtract(ctx, []string{file})
+	},
+	"diff": func(ctx context.Context, family *dumps.Family, file string, args []string) int {
+		return runDiff(ctx, []string{file})
+	},
+	"import": func(ctx context.Context, family *dumps.Family, file string, args []string) int {
+		if len(args) == 0 {
+			fmt.Println("import expects an importer")
+			return 2
+		}
+		return runImport(ctx, append([]string{args[0], file}, args[1:]...))
+	},
+	"apply": func(ctx context.Context, family *dumps.Family, file string, args []string) int {
+		if len(args) == 0 {
+			fmt.Println("apply expects an importer")
+			return 2
+		}
+		name := family.Find(filepath.Base(file))
+		if !utils.Exists(snapshot.ChangesetPath(GetSnapshotDir(family), name)) {
+			fmt.Printf("%s has no changeset, nothing to apply\n", name)
+			return 0
+		}
+		return runApply(ctx, append([]string{args[0], name}, args[1:]...))
+	},
+}
+
+// parseStep splits a step like "import sqlite books.sqlite" into its command
+// and arguments.
+func parseStep(step string) ([]string, error) {
+	fields := strings.Fields(step)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty step")
+	}
+	if _, ok := watchSteps[strings.ToLower(fields[0])]; !ok {
+		return nil, fmt.Errorf("unknown step %q, expected verify, extract, diff, import <kind> [target] or apply <kind> [target]", step)
+	}
+	fields[0] = strings.ToLower(fields[0])
+	return fields, nil
+}
+
+// stepList collects the repeated -then flags of watch.
+type stepList []string
+
+func (s *stepList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stepList) Set(value string) error {
+	if _, err := parseStep(value); err != nil {
+		return err
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+func runWatch(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	spec := flags.String("schedule", Watch.Schedule, "when the mirrors are checked, five cron fields or @hourly, @daily, @weekly, @monthly or @every <duration>")
+	then := stepList{}
+	flags.Var(&then, "then", "command run on every new dump: verify, extract, diff, import <kind> [target] or apply <kind> [target], repeatable")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s watch [flags]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(flags.Output(), "Checks the mirrors at start and then on the schedule, downloads the latest dump of every family when it is newer than the last complete one and runs the steps on it.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Println("watch expects no arguments")
+		return 2
+	}
+	sched, err := schedule.Parse(*spec)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if len(then) == 0 {
+		then = Watch.Then
+	}
+	steps := make([][]string, 0, len(then))
+	for _, step := range then {
+		fields, err := parseStep(step)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		steps = append(steps, fields)
+	}
+	if !lockInstance() {
+		return 1
+	}
+	names := make([]string, 0, len(families))
+	for _, family := range families {
+		names = append(names, family.Name)
+	}
+	fmt.Printf("Watching %s on %q\n", strings.Join(names, ", "), sched)
+	for {
+		checkForDumps(ctx, steps)
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			fmt.Printf("%q never fires again\n", sched)
+			return 1
+		}
+		fmt.Printf("Next check at %s\n", next.Format("2006-01-02 15:04 MST"))
+		if utils.Sleep(ctx, time.Until(next)) != nil {
+			return 0
+		}
+	}
+}
+
+// checkForDumps lists the mirrors once and brings every family up to date.
+func checkForDumps(ctx context.Context, steps [][]string) {
+	available, mirror := ListDumps(ctx)
+	if len(mirror) == 0 {
+		fmt.Println("No mirror could be listed")
+		return
+	}
+	for _, family := range families {
+		if ctx.Err() != nil {
+			return
+		}
+		watchFamily(ctx, family, available, mirror, steps)
+	}
+}
+
+// LastCompleteDump returns the newest complete dump of a family, or an empty
+// string.
+func LastCompleteDump(family *dumps.Family) string {
+	names, _ := LocalDumps(family)
+	for _, name := range names {
+		if IsDumpComplete(family, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// watchFamily downloads the latest dump of a family when it is newer than
+// the last complete one and runs the steps on it. A download failing three
+// times is resumed on the next check, as are the steps that did not finish.
+func watchFamily(ctx context.Context, family *dumps.Family, available []string, mirror string, steps [][]string) {
+	latest := family.Latest(available)
+	if len(latest) == 0 {
+		fmt.Printf("%s: no dump on the mirror\n", family.Name)
+		return
+	}
+	name := family.Find(mirrors.Name(latest[0]))
+	last := LastCompleteDump(family)
+	if !family.Newer(name, last) {
+		fmt.Printf("%s: %s is up to date\n", family.Name, last)
+		runSteps(ctx, family, last, steps)
+		return
+	}
+	hooks.Fire(ctx, hooks.Event{Event: hooks.Discovered, Family: family.Name, Dump: name, Url: mirrors.Link(mirror, latest[0])})
+	if len(last) > 0 {
+		fmt.Printf("%s: %s is newer than %s\n", family.Name, name, last)
+		if Changesets {
+			// the last dump is the base of the changeset of the new one
+			IndexDump(ctx, family, filepath.Join(GetFamilyDir(family), last+".rar"))
+		}
+	} else {
+		fmt.Printf("%s: downloading %s\n", family.Name, name)
+	}
+	completed := false
+	for attempt := 1; attempt <= 3 && !completed; attempt++ {
+		if attempt > 1 && utils.Sleep(ctx, time.Second*10) != nil {
+			return
+		}
+		link, size, err := remoteDump(ctx, mirrors.Name(latest[0]))
+		if err != nil {
+			fmt.Printf("Failed to find %s: %v\n", name, err)
+			continue
+		}
+		completed = DownloadDump(ctx, family, link, size)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if !completed {
+		fmt.Printf("Failed to download %s, resuming on the next check\n", name)
+		return
+	}
+	utils.WriteFile(GetDownloadedSignalFile(family), []byte(""))
+	runSteps(ctx, family, name, steps)
+}
+
+// runSteps runs the steps that did not finish on a complete dump yet, the
+// manifest of the dump records the ones that did. Dumps downloaded before
+// manifests were kept have no record and are left alone.
+func runSteps(ctx context.Context, family *dumps.Family, name string, steps [][]string) {
+	file := filepath.Join(GetFamilyDir(family), name+".rar")
+	m, err := manifest.Load(manifest.PathFor(file))
+	if err != nil {
+		return
+	}
+	for _, step := range steps {
+		command := strings.Join(step, " ")
+		if m.StepDone(command) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("Running %s on %s\n", command, name)
+		status := watchSteps[step[0]](ctx, family, file, step[1:])
+		if ctx.Err() != nil {
+			// an interrupted step is not recorded and runs again
+			return
+		}
+		if status != 0 {
+			fmt.Printf("%s failed on %s, running it again on the next check\n", step[0], name)
+			continue
+		}
+		if err = m.SetStepDone(command); err != nil {
+			fmt.Printf("Failed to record %s on %s: %v\n", step[0], name, err)
+		}
+	}
+}
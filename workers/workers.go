@@ -0,0 +1,153 @@
+package workers
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Collapse is the share of the throughput of the previous round below which
+// a round counts as congested.
+const Collapse = 2.0 / 3
+
+// Pool runs tasks on a bounded number of goroutines. The number of tasks run
+// at once adapts between a minimum and a maximum the way TCP adapts its
+// window: it grows by one after every round of tasks that kept up the
+// throughput, halves when a task fails and shrinks by a quarter when the
+// throughput of a round collapses. A round ends once as many tasks as the
+// limit finished.
+type Pool struct {
+	min, max int
+	lck      sync.Mutex
+	wg       sync.WaitGroup
+	// wake is closed and replaced whenever a task finishes
+	wake    chan struct{}
+	limit   float64
+	running int
+	round   int
+	started time.Time
+	// finished and bytes count the tasks of the current round
+	finished int
+	bytes    int64
+	// rate is the throughput of the previous round, 0 after a decrease
+	rate float64
+}
+
+// New returns a pool running size tasks at once at first, size is clamped to
+// min and max.
+func New(min, max, size int) *Pool {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	p := &Pool{min: min, max: max, wake: make(chan struct{}), started: time.Now()}
+	p.limit = p.clamp(float64(size))
+	return p
+}
+
+func (p *Pool) clamp(limit float64) float64 {
+	if limit < float64(p.min) {
+		return float64(p.min)
+	}
+	if limit > float64(p.max) {
+		return float64(p.max)
+	}
+	return limit
+}
+
+// Limit returns how many tasks run at once.
+func (p *Pool) Limit() int {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	return int(p.limit)
+}
+
+// Go waits for a free slot and runs task in the background, task returns the
+// bytes it transferred. Once ctx is done Go returns ctx.Err() without running
+// task, tasks failing after ctx is done do not shrink the pool.
+func (p *Pool) Go(ctx context.Context, task func() (int64, error)) error {
+	for {
+		p.lck.Lock()
+		if p.running < int(p.limit) {
+			break
+		}
+		wake := p.wake
+		p.lck.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+	if ctx.Err() != nil {
+		p.lck.Unlock()
+		return ctx.Err()
+	}
+	p.running++
+	round := p.round
+	p.wg.Add(1)
+	p.lck.Unlock()
+	go func() {
+		defer p.wg.Done()
+		n, err := task()
+		p.done(ctx, round, n, err)
+	}()
+	return nil
+}
+
+// Wait waits for the tasks started so far.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool) done(ctx context.Context, round int, n int64, err error) {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	p.running--
+	close(p.wake)
+	p.wake = make(chan struct{})
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		// tasks started before the last decrease failed under the old limit
+		// and do not halve it again
+		if round == p.round {
+			p.decrease(0.5)
+		}
+		return
+	}
+	p.finished++
+	p.bytes += n
+	if p.finished < int(p.limit) {
+		return
+	}
+	elapsed := time.Since(p.started).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(p.bytes) / elapsed
+	if p.rate > 0 && rate < p.rate*Collapse {
+		p.decrease(0.75)
+		return
+	}
+	p.limit = p.clamp(p.limit + 1)
+	p.next()
+	p.rate = rate
+}
+
+func (p *Pool) decrease(factor float64) {
+	p.limit = p.clamp(math.Floor(p.limit * factor))
+	p.next()
+	p.rate = 0
+}
+
+func (p *Pool) next() {
+	p.round++
+	p.started = time.Now()
+	p.finished = 0
+	p.bytes = 0
+}
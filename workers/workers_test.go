@@ -0,0 +1,132 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+// round finishes tasks of the current round that transferred n bytes each
+// over elapsed.
+func round(p *Pool, tasks int, n int64, elapsed time.Duration) {
+	p.started = time.Now().Add(-elapsed)
+	r := p.round
+	for i := 0; i < tasks; i++ {
+		p.running++
+		p.done(context.Background(), r, n, nil)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	ctx := context.Background()
+	p := New(1, 8, 2)
+	check := func(step string, want int) {
+		t.Helper()
+		if got := p.Limit(); got != want {
+			t.Fatalf("%s: Limit = %d, want %d", step, got, want)
+		}
+	}
+	check("start", 2)
+
+	// a round ends once as many tasks as the limit finished
+	round(p, 1, 100, time.Second)
+	check("half a round", 2)
+	round(p, 1, 100, time.Second)
+	check("first round", 3)
+	round(p, 3, 100, time.Second)
+	check("second round", 4)
+	round(p, 4, 100, time.Second)
+	check("third round", 5)
+
+	// a failure halves the limit and starts a new round
+	stale := p.round
+	round(p, 2, 100, time.Second)
+	p.running++
+	p.done(ctx, p.round, 0, errFailed)
+	check("failure", 2)
+
+	// tasks started before the decrease fail under the old limit
+	p.running++
+	p.done(ctx, stale, 0, errFailed)
+	check("stale failure", 2)
+
+	// the round after a decrease sets the throughput the next one is
+	// compared with
+	round(p, 2, 100, time.Second)
+	check("after the failure", 3)
+	round(p, 3, 100, time.Second)
+	check("steady", 4)
+
+	// a round with less than two thirds of the throughput shrinks the
+	// limit by a quarter
+	round(p, 4, 100, 3*time.Second)
+	check("collapse", 3)
+	round(p, 3, 100, time.Second)
+	check("after the collapse", 4)
+
+	// failures after ctx is done do not count
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	p.running++
+	p.done(cancelled, p.round, 0, errFailed)
+	check("cancelled", 4)
+}
+
+func TestClamp(t *testing.T) {
+	p := New(2, 4, 10)
+	if got := p.Limit(); got != 4 {
+		t.Fatalf("Limit = %d, want the maximum 4", got)
+	}
+	round(p, 4, 100, time.Second)
+	if got := p.Limit(); got != 4 {
+		t.Errorf("Limit = %d after a round, want the maximum 4", got)
+	}
+	for i := 0; i < 3; i++ {
+		p.running++
+		p.done(context.Background(), p.round, 0, errFailed)
+	}
+	if got := p.Limit(); got != 2 {
+		t.Errorf("Limit = %d after failures, want the minimum 2", got)
+	}
+	if p := New(0, 0, 0); p.Limit() != 1 {
+		t.Errorf("New(0, 0, 0) runs %d tasks at once, want 1", p.Limit())
+	}
+}
+
+func TestGo(t *testing.T) {
+	p := New(2, 2, 2)
+	ctx := context.Background()
+	release := make(chan struct{})
+	var running, most atomic.Int32
+	for i := 0; i < 6; i++ {
+		err := p.Go(ctx, func() (int64, error) {
+			n := running.Add(1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			<-release
+			running.Add(-1)
+			return 1, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// the pool is full until a task finishes
+			close(release)
+		}
+	}
+	p.Wait()
+	if most.Load() > 2 {
+		t.Errorf("%d tasks ran at once, want at most 2", most.Load())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := p.Go(cancelled, func() (int64, error) { return 0, nil }); err != context.Canceled {
+		t.Errorf("Go after ctx is done = %v, want %v", err, context.Canceled)
+	}
+}